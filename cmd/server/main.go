@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"expvar"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"sync"
+	"syscall"
 
 	gormmysql "gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -12,7 +20,9 @@ import (
 	migratemysql "github.com/golang-migrate/migrate/v4/database/mysql"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 
+	"merch-app-codex/internal/auth"
 	"merch-app-codex/internal/config"
+	"merch-app-codex/internal/mail"
 	"merch-app-codex/internal/report"
 	"merch-app-codex/internal/server"
 	storage "merch-app-codex/internal/storage/mysql"
@@ -25,20 +35,144 @@ func main() {
 		log.Fatalf("failed to run migrations: %v", err)
 	}
 
-	gormDB, err := gorm.Open(gormmysql.Open(cfg.DSN()), &gorm.Config{})
+	precision := storage.TimestampPrecision
+	gormDB, err := gorm.Open(gormmysql.New(gormmysql.Config{
+		DSN:                      cfg.DSN(),
+		DefaultDatetimePrecision: &precision,
+	}), &gorm.Config{})
 	if err != nil {
 		log.Fatalf("failed to connect database: %v", err)
 	}
 
 	repo := storage.NewRepository(gormDB)
-	authRepo := storage.NewAuthRepository(gormDB)
+	var tokenCache *storage.TokenCache
+	if cfg.TokenCacheSize > 0 && cfg.TokenCacheTTL > 0 {
+		tokenCache = storage.NewTokenCache(cfg.TokenCacheSize, cfg.TokenCacheTTL)
+		if err := tokenCache.Register(gormDB); err != nil {
+			log.Fatalf("failed to register token cache: %v", err)
+		}
+	}
+
+	authRepo := storage.NewAuthRepository(gormDB, tokenCache)
 	reportService := report.NewService(repo)
 
-	router := server.NewRouter(cfg, repo, authRepo, reportService)
+	mailer, err := mail.New(mail.Config{
+		Driver:       cfg.MailDriver,
+		From:         cfg.MailFrom,
+		Dir:          cfg.MailDir,
+		SMTPHost:     cfg.SMTPHost,
+		SMTPPort:     cfg.SMTPPort,
+		SMTPUsername: cfg.SMTPUsername,
+		SMTPPassword: cfg.SMTPPassword,
+	})
+	if err != nil {
+		log.Fatalf("failed to configure mailer: %v", err)
+	}
+
+	passwordPolicy, err := auth.NewPasswordPolicy(auth.PasswordPolicyConfig{
+		MinLength:     cfg.PasswordMinLength,
+		MaxLength:     cfg.PasswordMaxLength,
+		RequireUpper:  cfg.PasswordUpper,
+		RequireLower:  cfg.PasswordLower,
+		RequireDigit:  cfg.PasswordDigit,
+		RequireSymbol: cfg.PasswordSymbol,
+		BlocklistFile: cfg.PasswordBlocklist,
+	})
+	if err != nil {
+		log.Fatalf("failed to configure password policy: %v", err)
+	}
+
+	var signer *auth.TokenSigner
+	switch cfg.TokenMode {
+	case auth.TokenModeOpaque:
+	case auth.TokenModeSigned:
+		keys, err := auth.ParseSigningKeys(cfg.TokenSigningKeys)
+		if err != nil {
+			log.Fatalf("failed to parse token signing keys: %v", err)
+		}
+		if signer, err = auth.NewTokenSigner(cfg.AppURL, keys); err != nil {
+			log.Fatalf("failed to configure token signer: %v", err)
+		}
+	default:
+		log.Fatalf("unknown AUTH_TOKEN_MODE %q", cfg.TokenMode)
+	}
+
+	backends := make([]auth.Authenticator, 0, len(cfg.AuthBackends))
+	for _, name := range cfg.AuthBackends {
+		switch name {
+		case auth.BackendLocal:
+			backends = append(backends, auth.NewLocalAuthenticator(authRepo))
+		case auth.BackendLDAP:
+			ldapAuth, err := auth.NewLDAPAuthenticator(authRepo, auth.LDAPConfig{
+				URL:            cfg.LDAPURL,
+				StartTLS:       cfg.LDAPStartTLS,
+				BindDN:         cfg.LDAPBindDN,
+				BindPassword:   cfg.LDAPBindPassword,
+				BaseDN:         cfg.LDAPBaseDN,
+				UserFilter:     cfg.LDAPUserFilter,
+				EmailAttribute: cfg.LDAPEmailAttribute,
+				NameAttribute:  cfg.LDAPNameAttribute,
+				AutoProvision:  cfg.LDAPAutoProvision,
+				DefaultRole:    storage.Role(cfg.LDAPDefaultRole),
+				Timeout:        cfg.LDAPTimeout,
+			})
+			if err != nil {
+				log.Fatalf("failed to configure ldap authentication: %v", err)
+			}
+			backends = append(backends, ldapAuth)
+		default:
+			log.Fatalf("unknown authentication backend %q", name)
+		}
+	}
+	authenticator, err := auth.NewChainAuthenticator(backends...)
+	if err != nil {
+		log.Fatalf("failed to configure authentication: %v", err)
+	}
+
+	router := server.NewRouter(cfg, repo, authRepo, reportService, mailer, passwordPolicy, signer, authenticator)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var workers sync.WaitGroup
+	if cfg.TokenPurgeInterval > 0 {
+		purger := auth.NewTokenPurger(authRepo, auth.PurgeConfig{
+			Interval:    cfg.TokenPurgeInterval,
+			IdleTimeout: cfg.TokenIdleTimeout,
+		})
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			purger.Run(ctx)
+		}()
+	}
+
+	servers := []*http.Server{{Addr: ":" + cfg.Port, Handler: router}}
+	if cfg.MetricsAddr != "" {
+		// Metrics are served on a separate, typically internal, address.
+		metrics := http.NewServeMux()
+		metrics.Handle("/debug/vars", expvar.Handler())
+		servers = append(servers, &http.Server{Addr: cfg.MetricsAddr, Handler: metrics})
+	}
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("failed to start server on %s: %v", srv.Addr, err)
+			}
+		}(srv)
+	}
+
+	<-ctx.Done()
+	log.Println("shutting down")
 
-	if err := router.Run(":" + cfg.Port); err != nil {
-		log.Fatalf("failed to start server: %v", err)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("failed to shut down server on %s: %v", srv.Addr, err)
+		}
 	}
+	workers.Wait()
 }
 
 func runMigrations(cfg config.Config) error {
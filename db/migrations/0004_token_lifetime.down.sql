@@ -0,0 +1,5 @@
+ALTER TABLE user_tokens
+    DROP INDEX idx_user_tokens_refresh_token,
+    DROP COLUMN refresh_expires_at,
+    DROP COLUMN refresh_token,
+    DROP COLUMN last_used_at;
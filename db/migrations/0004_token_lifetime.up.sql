@@ -0,0 +1,5 @@
+ALTER TABLE user_tokens
+    ADD COLUMN last_used_at DATETIME NULL AFTER created_at,
+    ADD COLUMN refresh_token CHAR(64) NULL AFTER token,
+    ADD COLUMN refresh_expires_at DATETIME NULL AFTER expires_at,
+    ADD UNIQUE INDEX idx_user_tokens_refresh_token (refresh_token);
@@ -0,0 +1,2 @@
+-- Digests cannot be turned back into bearer tokens, so every session is revoked.
+DELETE FROM user_tokens;
@@ -0,0 +1,3 @@
+UPDATE user_tokens
+SET token = SHA2(token, 256),
+    refresh_token = SHA2(refresh_token, 256);
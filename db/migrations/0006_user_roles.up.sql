@@ -0,0 +1,4 @@
+ALTER TABLE users
+    ADD COLUMN role VARCHAR(32) NOT NULL DEFAULT 'merchandiser' AFTER password;
+
+UPDATE users SET role = 'admin' WHERE email = 'administrator@example.com';
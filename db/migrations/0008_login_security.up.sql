@@ -0,0 +1,24 @@
+CREATE TABLE login_throttles (
+    kind VARCHAR(16) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at DATETIME NULL,
+    locked_until DATETIME NULL,
+    PRIMARY KEY (kind, subject)
+) ENGINE=InnoDB;
+
+CREATE TABLE security_events (
+    id CHAR(26) NOT NULL PRIMARY KEY,
+    type VARCHAR(64) NOT NULL,
+    user_id CHAR(26) NULL,
+    email VARCHAR(255) NULL,
+    ip VARCHAR(64) NULL,
+    user_agent VARCHAR(512) NULL,
+    detail VARCHAR(255) NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_security_events_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+) ENGINE=InnoDB;
+
+CREATE INDEX idx_security_events_type_created ON security_events(type, created_at);
+CREATE INDEX idx_security_events_email ON security_events(email);
+CREATE INDEX idx_security_events_ip ON security_events(ip);
@@ -0,0 +1,9 @@
+CREATE TABLE password_reset_tokens (
+    id CHAR(26) NOT NULL PRIMARY KEY,
+    user_id CHAR(26) NOT NULL,
+    token CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME NULL,
+    CONSTRAINT fk_password_reset_tokens_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
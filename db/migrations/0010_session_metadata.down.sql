@@ -0,0 +1,4 @@
+ALTER TABLE user_tokens
+    DROP COLUMN ip,
+    DROP COLUMN user_agent,
+    DROP COLUMN device_label;
@@ -0,0 +1,4 @@
+ALTER TABLE user_tokens
+    ADD COLUMN device_label VARCHAR(255) NULL AFTER refresh_token,
+    ADD COLUMN user_agent VARCHAR(512) NULL AFTER device_label,
+    ADD COLUMN ip VARCHAR(64) NULL AFTER user_agent;
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ADD COLUMN oidc_subject VARCHAR(255) NULL AFTER role,
+    ADD UNIQUE INDEX idx_users_oidc_subject (oidc_subject);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS role_policies;
+DROP TABLE IF EXISTS two_factor_challenges;
+
+ALTER TABLE users
+    DROP COLUMN totp_recovery_codes,
+    DROP COLUMN totp_last_step,
+    DROP COLUMN totp_enabled_at,
+    DROP COLUMN totp_secret;
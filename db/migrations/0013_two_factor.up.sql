@@ -0,0 +1,21 @@
+ALTER TABLE users
+    ADD COLUMN totp_secret VARCHAR(64) NULL AFTER oidc_subject,
+    ADD COLUMN totp_enabled_at DATETIME NULL AFTER totp_secret,
+    ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0 AFTER totp_enabled_at,
+    ADD COLUMN totp_recovery_codes TEXT NULL AFTER totp_last_step;
+
+CREATE TABLE two_factor_challenges (
+    id CHAR(26) NOT NULL PRIMARY KEY,
+    user_id CHAR(26) NOT NULL,
+    token CHAR(64) NOT NULL UNIQUE,
+    device_label VARCHAR(255) NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    CONSTRAINT fk_two_factor_challenges_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
+
+CREATE TABLE role_policies (
+    role VARCHAR(32) NOT NULL PRIMARY KEY,
+    require_two_factor TINYINT(1) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB;
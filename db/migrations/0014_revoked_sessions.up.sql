@@ -0,0 +1,7 @@
+CREATE TABLE revoked_sessions (
+    session_id CHAR(26) NOT NULL PRIMARY KEY,
+    user_id CHAR(26) NOT NULL,
+    revoked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    INDEX idx_revoked_sessions_expires_at (expires_at)
+) ENGINE=InnoDB;
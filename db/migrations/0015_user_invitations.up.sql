@@ -0,0 +1,14 @@
+CREATE TABLE user_invitations (
+    id CHAR(26) NOT NULL PRIMARY KEY,
+    user_id CHAR(26) NULL,
+    email VARCHAR(255) NOT NULL,
+    token CHAR(64) NOT NULL UNIQUE,
+    invited_by CHAR(26) NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    accepted_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    INDEX idx_user_invitations_email (email),
+    CONSTRAINT fk_user_invitations_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    CONSTRAINT fk_user_invitations_invited_by FOREIGN KEY (invited_by) REFERENCES users(id) ON DELETE SET NULL
+) ENGINE=InnoDB;
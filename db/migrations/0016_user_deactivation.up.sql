@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ADD COLUMN active TINYINT(1) NOT NULL DEFAULT 1 AFTER role,
+    ADD COLUMN deactivated_at DATETIME NULL AFTER active;
@@ -0,0 +1,7 @@
+ALTER TABLE security_events
+    DROP FOREIGN KEY fk_security_events_actor,
+    DROP COLUMN actor_id;
+
+ALTER TABLE user_tokens
+    DROP FOREIGN KEY fk_user_tokens_impersonator,
+    DROP COLUMN impersonator_id;
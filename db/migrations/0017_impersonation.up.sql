@@ -0,0 +1,7 @@
+ALTER TABLE user_tokens
+    ADD COLUMN impersonator_id CHAR(26) NULL AFTER user_id,
+    ADD CONSTRAINT fk_user_tokens_impersonator FOREIGN KEY (impersonator_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE security_events
+    ADD COLUMN actor_id CHAR(26) NULL AFTER user_id,
+    ADD CONSTRAINT fk_security_events_actor FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL;
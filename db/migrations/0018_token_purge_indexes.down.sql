@@ -0,0 +1,4 @@
+DROP INDEX idx_password_reset_tokens_expires_at ON password_reset_tokens;
+DROP INDEX idx_two_factor_challenges_expires_at ON two_factor_challenges;
+DROP INDEX idx_user_tokens_last_used_at ON user_tokens;
+DROP INDEX idx_user_tokens_expires_at ON user_tokens;
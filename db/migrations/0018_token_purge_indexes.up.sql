@@ -0,0 +1,4 @@
+CREATE INDEX idx_user_tokens_expires_at ON user_tokens(expires_at);
+CREATE INDEX idx_user_tokens_last_used_at ON user_tokens(last_used_at);
+CREATE INDEX idx_two_factor_challenges_expires_at ON two_factor_challenges(expires_at);
+CREATE INDEX idx_password_reset_tokens_expires_at ON password_reset_tokens(expires_at);
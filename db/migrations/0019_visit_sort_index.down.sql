@@ -0,0 +1 @@
+DROP INDEX idx_visits_visited_at ON visits;
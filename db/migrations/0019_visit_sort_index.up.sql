@@ -0,0 +1 @@
+CREATE INDEX idx_visits_visited_at ON visits(visited_at, id);
@@ -0,0 +1,4 @@
+DROP INDEX ft_brands_search ON brands;
+DROP INDEX ft_companies_search ON companies;
+DROP INDEX ft_retail_points_search ON retail_points;
+DROP INDEX ft_products_search ON products;
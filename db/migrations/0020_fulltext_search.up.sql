@@ -0,0 +1,4 @@
+ALTER TABLE products ADD FULLTEXT INDEX ft_products_search (name, sku) WITH PARSER ngram;
+ALTER TABLE retail_points ADD FULLTEXT INDEX ft_retail_points_search (name, address) WITH PARSER ngram;
+ALTER TABLE companies ADD FULLTEXT INDEX ft_companies_search (name) WITH PARSER ngram;
+ALTER TABLE brands ADD FULLTEXT INDEX ft_brands_search (name) WITH PARSER ngram;
@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// Token modes selectable by configuration.
+const (
+	// TokenModeOpaque issues random access tokens that are looked up in MySQL.
+	TokenModeOpaque = "opaque"
+	// TokenModeSigned issues JWT access tokens verified without a database query.
+	TokenModeSigned = "jwt"
+)
+
+// minSigningKeyLen is the smallest accepted HS256 secret, in bytes.
+const minSigningKeyLen = 32
+
+var (
+	errMalformedToken = errors.New("malformed access token")
+	errTokenSignature = errors.New("invalid access token signature")
+	errTokenExpired   = errors.New("access token expired")
+)
+
+// AccessClaims are the claims carried by a signed access token. SessionID is the
+// ID of the stored session the token was issued for. CompanyIDs is the data scope
+// of non-admin users; it is nil for administrators and in tokens issued before
+// the claim was introduced.
+type AccessClaims struct {
+	Issuer     string       `json:"iss"`
+	Subject    string       `json:"sub"`
+	SessionID  string       `json:"sid"`
+	Roles      []mysql.Role `json:"roles"`
+	CompanyIDs []string     `json:"cid"`
+	TwoFactor  bool         `json:"tfa,omitempty"`
+	Actor      *ActorClaim  `json:"act,omitempty"`
+	IssuedAt   int64        `json:"iat"`
+	ExpiresAt  int64        `json:"exp"`
+}
+
+// ActorClaim names the administrator acting as the subject of an impersonation
+// token, following the "act" claim of RFC 8693.
+type ActorClaim struct {
+	Subject string `json:"sub"`
+}
+
+// SigningKey is an HS256 secret identified by the "kid" header of the tokens it signs.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseSigningKeys reads keys written as "kid:base64secret" separated by commas.
+// The first key signs new tokens; the others only verify tokens issued before a
+// rotation until they expire.
+func ParseSigningKeys(spec string) ([]SigningKey, error) {
+	var keys []SigningKey
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("signing key %q must be written as kid:base64secret", entry)
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", id, err)
+		}
+		if len(secret) < minSigningKeyLen {
+			return nil, fmt.Errorf("signing key %q must be at least %d bytes", id, minSigningKeyLen)
+		}
+		keys = append(keys, SigningKey{ID: id, Secret: secret})
+	}
+	return keys, nil
+}
+
+// TokenSigner issues and verifies HS256 JWT access tokens.
+type TokenSigner struct {
+	issuer string
+	keys   []SigningKey
+}
+
+// NewTokenSigner constructs a TokenSigner. The first key is used for signing.
+func NewTokenSigner(issuer string, keys []SigningKey) (*TokenSigner, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+	return &TokenSigner{issuer: issuer, keys: keys}, nil
+}
+
+// Sign issues an access token for the user's session that expires at expiresAt.
+// companyIDs are the companies a non-admin user is bound to. A non-empty actorID
+// marks the session as impersonated by that user.
+func (s *TokenSigner) Sign(user *mysql.User, companyIDs []string, sessionID, actorID string, now, expiresAt time.Time) (string, error) {
+	key := s.keys[0]
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT", "kid": key.ID})
+	if err != nil {
+		return "", err
+	}
+	claims := AccessClaims{
+		Issuer:    s.issuer,
+		Subject:   user.ID,
+		SessionID: sessionID,
+		Roles:     []mysql.Role{user.Role},
+		TwoFactor: user.TwoFactorEnabled(),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	}
+	if user.Role != mysql.RoleAdmin {
+		// An empty list is kept as [] so that it is not mistaken for a missing claim.
+		claims.CompanyIDs = append([]string{}, companyIDs...)
+	}
+	if actorID != "" {
+		claims.Actor = &ActorClaim{Subject: actorID}
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sign(key.Secret, signingInput)), nil
+}
+
+// Verify checks the signature, issuer and expiry of an access token.
+func (s *TokenSigner) Verify(token string, now time.Time) (*AccessClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil || header.Alg != "HS256" {
+		return nil, errMalformedToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+
+	var key *SigningKey
+	for i := range s.keys {
+		if s.keys[i].ID == header.Kid {
+			key = &s.keys[i]
+			break
+		}
+	}
+	if key == nil || !hmac.Equal(signature, sign(key.Secret, parts[0]+"."+parts[1])) {
+		return nil, errTokenSignature
+	}
+
+	rawPayload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	var claims AccessClaims
+	if err := json.Unmarshal(rawPayload, &claims); err != nil {
+		return nil, errMalformedToken
+	}
+	if claims.Issuer != s.issuer || claims.Subject == "" || claims.SessionID == "" || len(claims.Roles) == 0 {
+		return nil, errMalformedToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, errTokenExpired
+	}
+
+	return &claims, nil
+}
+
+func sign(secret []byte, input string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	return mac.Sum(nil)
+}
+
+// IsSignedToken reports whether a bearer value has the shape of a JWT.
+func IsSignedToken(value string) bool {
+	return strings.Count(value, ".") == 2
+}
+
+// Denylist keeps revoked sessions in memory so that signed access tokens can be
+// rejected without a query per request. Revocations are stored in MySQL by the
+// repository and picked up by every instance within the refresh interval.
+type Denylist struct {
+	repo    Repository
+	refresh time.Duration
+
+	// loading is held by the request that reloads the list. Checks made meanwhile
+	// are answered from the previous list rather than waiting for the query.
+	loading sync.Mutex
+
+	mu       sync.RWMutex
+	sessions map[string]time.Time
+	loadedAt time.Time
+}
+
+// NewDenylist constructs a Denylist that reloads revoked sessions every refresh interval.
+func NewDenylist(repo Repository, refresh time.Duration) *Denylist {
+	return &Denylist{repo: repo, refresh: refresh}
+}
+
+// Revoked reports whether tokens of the session have been revoked.
+func (d *Denylist) Revoked(ctx context.Context, sessionID string, now time.Time) (bool, error) {
+	d.mu.RLock()
+	_, ok := d.sessions[sessionID]
+	loaded, stale := !d.loadedAt.IsZero(), now.Sub(d.loadedAt) >= d.refresh
+	d.mu.RUnlock()
+	if !stale {
+		return ok, nil
+	}
+
+	if loaded {
+		if !d.loading.TryLock() {
+			return ok, nil
+		}
+	} else {
+		// Nothing can be answered before the first load, so every check waits for it.
+		d.loading.Lock()
+	}
+	err := d.reload(ctx, now)
+	d.loading.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	d.mu.RLock()
+	_, ok = d.sessions[sessionID]
+	d.mu.RUnlock()
+	return ok, nil
+}
+
+// reload replaces the list with the revoked sessions stored in MySQL. It must be
+// called with d.loading held.
+func (d *Denylist) reload(ctx context.Context, now time.Time) error {
+	d.mu.RLock()
+	fresh := !d.loadedAt.IsZero() && now.Sub(d.loadedAt) < d.refresh
+	d.mu.RUnlock()
+	if fresh {
+		// Another check reloaded the list while this one waited.
+		return nil
+	}
+
+	revoked, err := d.repo.ListRevokedSessions(ctx, now)
+	if err != nil {
+		return err
+	}
+	sessions := make(map[string]time.Time, len(revoked))
+	for _, session := range revoked {
+		sessions[session.SessionID] = session.ExpiresAt
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Sessions added by Invalidate during the query may be missing from its result.
+	for sessionID, expiresAt := range d.sessions {
+		if expiresAt.After(now) {
+			sessions[sessionID] = expiresAt
+		}
+	}
+	d.sessions, d.loadedAt = sessions, now
+	return nil
+}
+
+// Invalidate adds a session revoked by this instance, so that the revocation
+// applies immediately rather than after the refresh interval.
+func (d *Denylist) Invalidate(sessionID string, expiresAt time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.sessions == nil {
+		d.sessions = make(map[string]time.Time)
+	}
+	d.sessions[sessionID] = expiresAt
+}
+
+// SessionsRevoked adds sessions revoked by this instance. It is registered with
+// the repository through OnSessionsRevoked.
+func (d *Denylist) SessionsRevoked(sessions []mysql.RevokedSession) {
+	for _, session := range sessions {
+		d.Invalidate(session.SessionID, session.ExpiresAt)
+	}
+}
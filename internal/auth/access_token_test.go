@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+func testSigningKey(id string, fill byte) SigningKey {
+	return SigningKey{ID: id, Secret: []byte(strings.Repeat(string(fill), minSigningKeyLen))}
+}
+
+func newTestSigner(t *testing.T, keys ...SigningKey) *TokenSigner {
+	t.Helper()
+	signer, err := NewTokenSigner("merch-app", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func signTestToken(t *testing.T, signer *TokenSigner, now, expiresAt time.Time) string {
+	t.Helper()
+	user := &mysql.User{Role: mysql.RoleSupervisor}
+	user.SetID("01HZY3S0MS1G5B2TQ4V6W8X0YZ")
+	token, err := signer.Sign(user, []string{"c1"}, "session-1", "", now, expiresAt)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return token
+}
+
+func TestTokenSignerRoundTrip(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer := newTestSigner(t, testSigningKey("k1", 'a'))
+
+	token := signTestToken(t, signer, now, now.Add(5*time.Minute))
+	if !IsSignedToken(token) {
+		t.Fatalf("token %q does not look like a JWT", token)
+	}
+
+	claims, err := signer.Verify(token, now)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	switch {
+	case claims.Subject != "01HZY3S0MS1G5B2TQ4V6W8X0YZ" || claims.SessionID != "session-1":
+		t.Fatalf("claims name subject %q and session %q", claims.Subject, claims.SessionID)
+	case len(claims.Roles) != 1 || claims.Roles[0] != mysql.RoleSupervisor:
+		t.Fatalf("roles %v, want [%s]", claims.Roles, mysql.RoleSupervisor)
+	case len(claims.CompanyIDs) != 1 || claims.CompanyIDs[0] != "c1":
+		t.Fatalf("company IDs %v, want [c1]", claims.CompanyIDs)
+	case claims.Actor != nil:
+		t.Fatalf("unexpected actor %v", claims.Actor)
+	}
+}
+
+func TestTokenSignerRejects(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer := newTestSigner(t, testSigningKey("k1", 'a'))
+	token := signTestToken(t, signer, now, now.Add(5*time.Minute))
+	parts := strings.Split(token, ".")
+
+	tampered := []byte(parts[2])
+	if tampered[0] == 'A' {
+		tampered[0] = 'B'
+	} else {
+		tampered[0] = 'A'
+	}
+
+	tests := []struct {
+		name    string
+		signer  *TokenSigner
+		token   string
+		now     time.Time
+		wantErr error
+	}{
+		{
+			name:    "tampered signature",
+			signer:  signer,
+			token:   parts[0] + "." + parts[1] + "." + string(tampered),
+			now:     now,
+			wantErr: errTokenSignature,
+		},
+		{
+			name:    "tampered payload",
+			signer:  signer,
+			token:   parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2],
+			now:     now,
+			wantErr: errTokenSignature,
+		},
+		{
+			name:    "unknown kid",
+			signer:  newTestSigner(t, testSigningKey("k2", 'a')),
+			token:   token,
+			now:     now,
+			wantErr: errTokenSignature,
+		},
+		{
+			name:    "expired",
+			signer:  signer,
+			token:   token,
+			now:     now.Add(5 * time.Minute),
+			wantErr: errTokenExpired,
+		},
+		{
+			name:    "other issuer",
+			signer:  &TokenSigner{issuer: "other", keys: signer.keys},
+			token:   token,
+			now:     now,
+			wantErr: errMalformedToken,
+		},
+		{
+			name:    "not a JWT",
+			signer:  signer,
+			token:   "opaque-token",
+			now:     now,
+			wantErr: errMalformedToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.signer.Verify(tt.token, tt.now); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenSignerKeyRotation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	oldKey, newKey := testSigningKey("k1", 'a'), testSigningKey("k2", 'b')
+
+	beforeRotation := signTestToken(t, newTestSigner(t, oldKey), now, now.Add(5*time.Minute))
+	rotated := newTestSigner(t, newKey, oldKey)
+	afterRotation := signTestToken(t, rotated, now, now.Add(5*time.Minute))
+
+	if _, err := rotated.Verify(beforeRotation, now); err != nil {
+		t.Fatalf("token signed before the rotation: %v", err)
+	}
+	if _, err := rotated.Verify(afterRotation, now); err != nil {
+		t.Fatalf("token signed after the rotation: %v", err)
+	}
+	if _, err := newTestSigner(t, oldKey).Verify(afterRotation, now); !errors.Is(err, errTokenSignature) {
+		t.Fatalf("new key accepted by a signer without it: %v", err)
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(strings.Split(afterRotation, ".")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(header), `"kid":"k2"`) {
+		t.Fatalf("header %s is not signed with the first key", header)
+	}
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", minSigningKeyLen)))
+	short := base64.StdEncoding.EncodeToString([]byte("short"))
+
+	keys, err := ParseSigningKeys(" k2:" + secret + ", ,k1:" + secret)
+	if err != nil {
+		t.Fatalf("ParseSigningKeys: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != "k2" || keys[1].ID != "k1" || len(keys[0].Secret) != minSigningKeyLen {
+		t.Fatalf("parsed keys %+v", keys)
+	}
+
+	for _, spec := range []string{secret, ":" + secret, "k1:not base64!", "k1:" + short} {
+		if _, err := ParseSigningKeys(spec); err == nil {
+			t.Fatalf("ParseSigningKeys(%q) accepted an invalid key", spec)
+		}
+	}
+}
+
+// revokedStore is a Repository serving ListRevokedSessions. A non-nil block
+// channel holds each query until a value is received from it.
+type revokedStore struct {
+	Repository
+
+	mu      sync.Mutex
+	revoked []mysql.RevokedSession
+	queries int
+	block   chan struct{}
+	started chan struct{}
+}
+
+func (s *revokedStore) ListRevokedSessions(ctx context.Context, now time.Time) ([]mysql.RevokedSession, error) {
+	s.mu.Lock()
+	s.queries++
+	revoked := append([]mysql.RevokedSession(nil), s.revoked...)
+	block, started := s.block, s.started
+	s.mu.Unlock()
+
+	if block != nil {
+		started <- struct{}{}
+		<-block
+	}
+	return revoked, nil
+}
+
+func (s *revokedStore) revoke(sessionID string, expiresAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked = append(s.revoked, mysql.RevokedSession{SessionID: sessionID, ExpiresAt: expiresAt})
+}
+
+func assertRevoked(t *testing.T, d *Denylist, sessionID string, now time.Time, want bool) {
+	t.Helper()
+	revoked, err := d.Revoked(context.Background(), sessionID, now)
+	if err != nil {
+		t.Fatalf("Revoked: %v", err)
+	}
+	if revoked != want {
+		t.Fatalf("Revoked(%q) = %v, want %v", sessionID, revoked, want)
+	}
+}
+
+func TestDenylistRefresh(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := &revokedStore{}
+	store.revoke("s1", now.Add(time.Hour))
+	d := NewDenylist(store, 10*time.Second)
+
+	assertRevoked(t, d, "s1", now, true)
+	assertRevoked(t, d, "s2", now, false)
+
+	// Revocations made elsewhere are picked up after the refresh interval.
+	store.revoke("s2", now.Add(time.Hour))
+	assertRevoked(t, d, "s2", now.Add(5*time.Second), false)
+	assertRevoked(t, d, "s2", now.Add(10*time.Second), true)
+	if store.queries != 2 {
+		t.Fatalf("queried %d times, want 2", store.queries)
+	}
+
+	// Revocations made by this instance apply immediately without a query.
+	d.Invalidate("s3", now.Add(time.Hour))
+	assertRevoked(t, d, "s3", now.Add(10*time.Second), true)
+	assertRevoked(t, d, "s1", now.Add(10*time.Second), true)
+	if store.queries != 2 {
+		t.Fatalf("Invalidate caused a reload: %d queries", store.queries)
+	}
+
+	// They outlive reloads that do not contain them yet, until they expire.
+	assertRevoked(t, d, "s3", now.Add(20*time.Second), true)
+	d.SessionsRevoked([]mysql.RevokedSession{{SessionID: "s4", ExpiresAt: now.Add(25 * time.Second)}})
+	assertRevoked(t, d, "s4", now.Add(30*time.Second), false)
+}
+
+func TestDenylistAnswersFromPreviousListWhileLoading(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := &revokedStore{}
+	store.revoke("s1", now.Add(time.Hour))
+	d := NewDenylist(store, 10*time.Second)
+	assertRevoked(t, d, "s1", now, true)
+
+	store.mu.Lock()
+	store.block, store.started = make(chan struct{}), make(chan struct{})
+	store.mu.Unlock()
+	store.revoke("s2", now.Add(time.Hour))
+
+	later := now.Add(time.Minute)
+	done := make(chan bool)
+	go func() {
+		revoked, _ := d.Revoked(context.Background(), "s2", later)
+		done <- revoked
+	}()
+	<-store.started
+
+	// The reload is stuck in the query; other checks must not wait for it.
+	assertRevoked(t, d, "s1", later, true)
+	assertRevoked(t, d, "s2", later, false)
+	d.Invalidate("s3", later.Add(time.Hour))
+
+	close(store.block)
+	if !<-done {
+		t.Fatal("reloading check did not see the session revoked meanwhile")
+	}
+	assertRevoked(t, d, "s3", later, true)
+	if store.queries != 2 {
+		t.Fatalf("queried %d times, want 2", store.queries)
+	}
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// APIKeyPrefix marks bearer values that are API keys rather than user tokens.
+const APIKeyPrefix = "mk_"
+
+// Scope verbs accepted on API keys in addition to the actions of Permission.
+// "write" covers creating, updating and deleting a resource.
+const scopeWrite = "write"
+
+var scopePattern = regexp.MustCompile(`^(\*|[a-z][a-z-]*):(\*|read|write|manage)$`)
+
+// IsAPIKey reports whether a bearer value has the shape of an API key.
+func IsAPIKey(value string) bool {
+	return strings.HasPrefix(value, APIKeyPrefix)
+}
+
+// NewAPIKey generates a key for the owner. The plain key is only available on the
+// returned model until it is persisted; the visible prefix identifies it later.
+func NewAPIKey(name, ownerID string, scopes []string, expiresAt *time.Time) (*mysql.APIKey, error) {
+	for _, scope := range scopes {
+		if !scopePattern.MatchString(scope) {
+			return nil, fmt.Errorf("invalid scope %q, expected resource:read|write|manage", scope)
+		}
+	}
+
+	prefixBytes := make([]byte, 4)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return nil, err
+	}
+	secret, err := GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := APIKeyPrefix + hex.EncodeToString(prefixBytes)
+	key := &mysql.APIKey{
+		Name:      name,
+		OwnerID:   ownerID,
+		Prefix:    prefix,
+		Key:       prefix + "_" + secret,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	key.SetID(mysql.NewID())
+
+	return key, nil
+}
+
+// ScopesAllow reports whether any of the API key scopes covers the required permission.
+func ScopesAllow(scopes []string, required Permission) bool {
+	requiredResource, requiredAction, _ := strings.Cut(string(required), ":")
+	for _, scope := range scopes {
+		resource, verb, _ := strings.Cut(scope, ":")
+		if resource != "*" && resource != requiredResource {
+			continue
+		}
+		switch verb {
+		case "*", requiredAction:
+			return true
+		case scopeWrite:
+			if requiredAction == string(ActionCreate) || requiredAction == string(ActionUpdate) || requiredAction == string(ActionDelete) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// Authentication backends selectable by configuration.
+const (
+	BackendLocal = "local"
+	BackendLDAP  = "ldap"
+)
+
+// ErrInvalidCredentials is returned by an Authenticator that does not accept
+// the credentials, whether because the account is unknown to it or because the
+// password is wrong.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator verifies login credentials and resolves them to a local user.
+type Authenticator interface {
+	// Name identifies the backend in configuration and security events.
+	Name() string
+	// Authenticate returns the local user for the credentials, ErrInvalidCredentials
+	// when the backend rejects them, or any other error when it cannot decide.
+	Authenticate(ctx context.Context, email, password string) (*mysql.User, error)
+}
+
+// ChainAuthenticator asks its backends in order and accepts the first user one
+// of them authenticates. A backend failing with anything other than
+// ErrInvalidCredentials stops the chain, so that an unavailable directory is
+// reported instead of being mistaken for a wrong password.
+type ChainAuthenticator struct {
+	backends []Authenticator
+}
+
+// NewChainAuthenticator constructs a ChainAuthenticator trying backends in the given order.
+func NewChainAuthenticator(backends ...Authenticator) (*ChainAuthenticator, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("at least one authentication backend is required")
+	}
+	return &ChainAuthenticator{backends: backends}, nil
+}
+
+// Authenticate returns the user and the name of the backend that accepted the credentials.
+func (a *ChainAuthenticator) Authenticate(ctx context.Context, email, password string) (*mysql.User, string, error) {
+	for _, backend := range a.backends {
+		user, err := backend.Authenticate(ctx, email, password)
+		if err == nil {
+			return user, backend.Name(), nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			return nil, backend.Name(), err
+		}
+	}
+	return nil, "", ErrInvalidCredentials
+}
+
+// LocalAuthenticator checks passwords against the hashes stored in MySQL.
+type LocalAuthenticator struct {
+	repo Repository
+}
+
+// NewLocalAuthenticator constructs a LocalAuthenticator.
+func NewLocalAuthenticator(repo Repository) *LocalAuthenticator {
+	return &LocalAuthenticator{repo: repo}
+}
+
+// Name implements Authenticator.
+func (a *LocalAuthenticator) Name() string {
+	return BackendLocal
+}
+
+// Authenticate implements Authenticator. Users without a local password, such
+// as directory users, are rejected.
+func (a *LocalAuthenticator) Authenticate(ctx context.Context, email, password string) (*mysql.User, error) {
+	user, err := a.repo.FindUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	// Unreadable hashes are treated like a wrong password, as they always were.
+	if err := user.CheckPassword(password); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
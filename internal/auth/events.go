@@ -0,0 +1,32 @@
+package auth
+
+// Security event types recorded by the authentication endpoints.
+const (
+	EventLoginSucceeded = "login_succeeded"
+	EventLoginFailed    = "login_failed"
+	EventLoginThrottled = "login_throttled"
+	EventLoginUnlocked  = "login_unlocked"
+
+	EventPasswordResetRequested = "password_reset_requested"
+	EventPasswordResetCompleted = "password_reset_completed"
+	EventPasswordChanged        = "password_changed"
+
+	EventTwoFactorEnabled  = "two_factor_enabled"
+	EventTwoFactorDisabled = "two_factor_disabled"
+	EventTwoFactorFailed   = "two_factor_failed"
+	EventTwoFactorReset    = "two_factor_reset"
+
+	EventInvitationSent     = "invitation_sent"
+	EventInvitationAccepted = "invitation_accepted"
+	EventInvitationRevoked  = "invitation_revoked"
+
+	EventUserDeactivated = "user_deactivated"
+	EventUserReactivated = "user_reactivated"
+
+	EventImpersonationStarted = "impersonation_started"
+	EventImpersonationEnded   = "impersonation_ended"
+	EventImpersonatedRequest  = "impersonated_request"
+
+	EventAPIKeyCreated = "api_key_created"
+	EventAPIKeyRevoked = "api_key_revoked"
+)
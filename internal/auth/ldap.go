@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// LDAPConfig describes the directory used by LDAPAuthenticator.
+type LDAPConfig struct {
+	// URL is an ldap:// or ldaps:// address of the directory server.
+	URL string
+	// StartTLS upgrades an ldap:// connection before any credentials are sent.
+	StartTLS bool
+	// BindDN and BindPassword identify the service account that looks users up.
+	// Both empty means an anonymous search.
+	BindDN       string
+	BindPassword string
+	// BaseDN is where user entries are searched.
+	BaseDN string
+	// UserFilter selects the entry of a login; %s is replaced by the escaped email.
+	UserFilter string
+	// EmailAttribute and NameAttribute are read from the user entry.
+	EmailAttribute string
+	NameAttribute  string
+	// AutoProvision creates local users for directory accounts without one,
+	// with DefaultRole.
+	AutoProvision bool
+	DefaultRole   mysql.Role
+	Timeout       time.Duration
+	// TLSConfig overrides the TLS settings of ldaps:// and StartTLS connections.
+	TLSConfig *tls.Config
+}
+
+// LDAPAuthenticator verifies passwords by binding to a directory as the user
+// and links the account to the local user with the same email.
+type LDAPAuthenticator struct {
+	repo Repository
+	cfg  LDAPConfig
+}
+
+// NewLDAPAuthenticator constructs an LDAPAuthenticator.
+func NewLDAPAuthenticator(repo Repository, cfg LDAPConfig) (*LDAPAuthenticator, error) {
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, errors.New("ldap authentication requires a URL and a base DN")
+	}
+	if (cfg.BindDN == "") != (cfg.BindPassword == "") {
+		return nil, errors.New("ldap bind DN and bind password must be set together")
+	}
+	if strings.Count(cfg.UserFilter, "%s") != 1 {
+		return nil, fmt.Errorf("ldap user filter %q must contain exactly one %%s", cfg.UserFilter)
+	}
+	if cfg.AutoProvision && !cfg.DefaultRole.Valid() {
+		return nil, fmt.Errorf("unknown ldap default role %q", cfg.DefaultRole)
+	}
+
+	return &LDAPAuthenticator{repo: repo, cfg: cfg}, nil
+}
+
+// Name implements Authenticator.
+func (a *LDAPAuthenticator) Name() string {
+	return BackendLDAP
+}
+
+// Authenticate implements Authenticator. The user entry is looked up by email
+// with the service account and the password is checked by binding as that entry.
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, email, password string) (*mysql.User, error) {
+	// An empty password would make the bind unauthenticated and always succeed.
+	if email == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: %w", err)
+	}
+	defer conn.Close()
+
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap: service bind: %w", err)
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(a.cfg.Timeout.Seconds()), false,
+		fmt.Sprintf(a.cfg.UserFilter, ldap.EscapeFilter(email)),
+		[]string{a.cfg.EmailAttribute, a.cfg.NameAttribute},
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldap: search: %w", err)
+	}
+	// Ambiguous logins are refused rather than bound to an arbitrary entry.
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap: user bind: %w", err)
+	}
+
+	directoryEmail := entry.GetAttributeValue(a.cfg.EmailAttribute)
+	if directoryEmail == "" {
+		directoryEmail = email
+	}
+	return a.linkUser(ctx, directoryEmail, entry.GetAttributeValue(a.cfg.NameAttribute))
+}
+
+// linkUser returns the local user with the directory email, provisioning one
+// when enabled. Provisioned users have no local password.
+func (a *LDAPAuthenticator) linkUser(ctx context.Context, email, name string) (*mysql.User, error) {
+	user, err := a.repo.FindUserByEmail(ctx, email)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if !a.cfg.AutoProvision {
+		return nil, ErrInvalidCredentials
+	}
+
+	if name == "" {
+		name = email
+	}
+	user = &mysql.User{Name: name, Email: email, Role: a.cfg.DefaultRole}
+	user.SetID(mysql.NewID())
+	if err := a.repo.CreateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (a *LDAPAuthenticator) dial(ctx context.Context) (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: a.cfg.Timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+
+	options := []ldap.DialOpt{ldap.DialWithDialer(dialer)}
+	if a.cfg.TLSConfig != nil {
+		options = append(options, ldap.DialWithTLSConfig(a.cfg.TLSConfig))
+	}
+
+	conn, err := ldap.DialURL(a.cfg.URL, options...)
+	if err != nil {
+		return nil, err
+	}
+	if a.cfg.Timeout > 0 {
+		conn.SetTimeout(a.cfg.Timeout)
+	}
+
+	if a.cfg.StartTLS {
+		tlsConfig := a.cfg.TLSConfig
+		if tlsConfig == nil {
+			parsed, err := url.Parse(a.cfg.URL)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			tlsConfig = &tls.Config{ServerName: parsed.Hostname()}
+		}
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,484 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+const (
+	testBaseDN          = "ou=people,dc=example,dc=com"
+	testServiceDN       = "cn=merch,ou=services,dc=example,dc=com"
+	testServicePassword = "service-secret"
+)
+
+type directoryEntry struct {
+	dn       string
+	password string
+	mail     string
+	name     string
+}
+
+// testDirectory is a minimal in-process LDAP server answering simple binds and
+// searches by mail, enough to drive LDAPAuthenticator over a real connection.
+type testDirectory struct {
+	t        *testing.T
+	listener net.Listener
+	entries  []directoryEntry
+
+	mu          sync.Mutex
+	connections int
+	binds       []string
+}
+
+func newTestDirectory(t *testing.T, entries ...directoryEntry) *testDirectory {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testDirectory{t: t, listener: listener, entries: entries}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			d.mu.Lock()
+			d.connections++
+			d.mu.Unlock()
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *testDirectory) url() string {
+	return "ldap://" + d.listener.Addr().String()
+}
+
+// boundDNs returns the DNs of all bind attempts, successful or not.
+func (d *testDirectory) boundDNs() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.binds...)
+}
+
+func (d *testDirectory) connectionCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.connections
+}
+
+func (d *testDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var bound string
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				d.t.Logf("ldap test server: %v", err)
+			}
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		var responses []*ber.Packet
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := request.Children[1].Data.String()
+			password := request.Children[2].Data.String()
+			d.mu.Lock()
+			d.binds = append(d.binds, dn)
+			d.mu.Unlock()
+
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if d.authenticates(dn, password) {
+				code, bound = ldap.LDAPResultSuccess, dn
+			}
+			responses = append(responses, ldapResult(id, ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			if bound != testServiceDN {
+				responses = append(responses, ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights))
+				break
+			}
+			base := request.Children[0].Data.String()
+			sizeLimit, _ := request.Children[3].Value.(int64)
+			filter, err := ldap.DecompileFilter(request.Children[6])
+			if err != nil {
+				responses = append(responses, ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError))
+				break
+			}
+
+			code := uint16(ldap.LDAPResultSuccess)
+			for _, entry := range d.search(base, filter) {
+				if sizeLimit > 0 && int64(len(responses)) == sizeLimit {
+					code = ldap.LDAPResultSizeLimitExceeded
+					break
+				}
+				responses = append(responses, searchEntry(id, entry))
+			}
+			responses = append(responses, ldapResult(id, ldap.ApplicationSearchResultDone, code))
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			return
+		}
+
+		for _, response := range responses {
+			if _, err := conn.Write(response.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// authenticates applies simple bind rules; like real servers, a bind with a DN
+// and an empty password is an unauthenticated bind and succeeds.
+func (d *testDirectory) authenticates(dn, password string) bool {
+	if password == "" {
+		return true
+	}
+	if dn == testServiceDN {
+		return password == testServicePassword
+	}
+	for _, entry := range d.entries {
+		if entry.dn == dn {
+			return entry.password == password
+		}
+	}
+	return false
+}
+
+func (d *testDirectory) search(base, filter string) []directoryEntry {
+	var found []directoryEntry
+	for _, entry := range d.entries {
+		if strings.HasSuffix(entry.dn, ","+base) && strings.Contains(filter, "(mail="+entry.mail+")") {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+func ldapResult(id int64, operation ber.Tag, code uint16) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, operation, nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return ldapMessage(id, response)
+}
+
+func searchEntry(id int64, entry directoryEntry) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "Object Name"))
+
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, value := range map[string]string{"mail": entry.mail, "cn": entry.name} {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		attribute.AppendChild(values)
+		attributes.AppendChild(attribute)
+	}
+	response.AppendChild(attributes)
+	return ldapMessage(id, response)
+}
+
+func ldapMessage(id int64, operation *ber.Packet) *ber.Packet {
+	message := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Message")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	message.AppendChild(operation)
+	return message
+}
+
+// userStore is an in-memory Repository covering the user lookups of the
+// authenticators. Other methods panic through the nil embedded interface.
+type userStore struct {
+	Repository
+	users []*mysql.User
+}
+
+func (s *userStore) FindUserByEmail(ctx context.Context, email string) (*mysql.User, error) {
+	for _, user := range s.users {
+		if user.Email == email {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *userStore) CreateUser(ctx context.Context, user *mysql.User) error {
+	copied := *user
+	s.users = append(s.users, &copied)
+	return nil
+}
+
+func newLocalUser(t *testing.T, email, password string) *mysql.User {
+	t.Helper()
+	user := &mysql.User{Name: email, Email: email, Password: password, Role: mysql.RoleSupervisor, Active: true}
+	user.SetID(mysql.NewID())
+	// BeforeSave hashes the plain-text password the way CreateUser would.
+	if err := user.BeforeSave(nil); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func newTestLDAPAuthenticator(t *testing.T, directory *testDirectory, repo Repository, configure func(*LDAPConfig)) *LDAPAuthenticator {
+	t.Helper()
+	cfg := LDAPConfig{
+		URL:            directory.url(),
+		BindDN:         testServiceDN,
+		BindPassword:   testServicePassword,
+		BaseDN:         testBaseDN,
+		UserFilter:     "(&(objectClass=person)(mail=%s))",
+		EmailAttribute: "mail",
+		NameAttribute:  "cn",
+		DefaultRole:    mysql.RoleMerchandiser,
+		Timeout:        5 * time.Second,
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+	authenticator, err := NewLDAPAuthenticator(repo, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authenticator
+}
+
+var (
+	ivanEntry = directoryEntry{dn: "uid=ivan," + testBaseDN, password: "directory-secret", mail: "ivan@example.com", name: "Ivan Petrov"}
+	annaEntry = directoryEntry{dn: "uid=anna," + testBaseDN, password: "directory-secret", mail: "anna@example.com", name: "Anna Sidorova"}
+)
+
+func TestLDAPAuthenticatorAuthenticate(t *testing.T) {
+	duplicate := func(entry directoryEntry, uid string) directoryEntry {
+		entry.dn = "uid=" + uid + "," + testBaseDN
+		return entry
+	}
+
+	tests := []struct {
+		name      string
+		entries   []directoryEntry
+		configure func(*LDAPConfig)
+		email     string
+		password  string
+		wantErr   error
+		wantText  string
+		wantBinds []string
+	}{
+		{
+			name:      "binds as the found entry",
+			entries:   []directoryEntry{ivanEntry, annaEntry},
+			email:     "ivan@example.com",
+			password:  "directory-secret",
+			wantBinds: []string{testServiceDN, ivanEntry.dn},
+		},
+		{
+			name:    "service bind failure",
+			entries: []directoryEntry{ivanEntry},
+			configure: func(cfg *LDAPConfig) {
+				cfg.BindPassword = "wrong-service-secret"
+			},
+			email:     "ivan@example.com",
+			password:  "directory-secret",
+			wantText:  "service bind",
+			wantBinds: []string{testServiceDN},
+		},
+		{
+			name:      "no entry",
+			entries:   []directoryEntry{annaEntry},
+			email:     "ivan@example.com",
+			password:  "directory-secret",
+			wantErr:   ErrInvalidCredentials,
+			wantBinds: []string{testServiceDN},
+		},
+		{
+			name:      "more than one entry",
+			entries:   []directoryEntry{ivanEntry, duplicate(ivanEntry, "ivan2")},
+			email:     "ivan@example.com",
+			password:  "directory-secret",
+			wantErr:   ErrInvalidCredentials,
+			wantBinds: []string{testServiceDN},
+		},
+		{
+			name:      "more entries than the size limit",
+			entries:   []directoryEntry{ivanEntry, duplicate(ivanEntry, "ivan2"), duplicate(ivanEntry, "ivan3")},
+			email:     "ivan@example.com",
+			password:  "directory-secret",
+			wantErr:   ErrInvalidCredentials,
+			wantBinds: []string{testServiceDN},
+		},
+		{
+			name:      "wrong user password",
+			entries:   []directoryEntry{ivanEntry},
+			email:     "ivan@example.com",
+			password:  "guessed",
+			wantErr:   ErrInvalidCredentials,
+			wantBinds: []string{testServiceDN, ivanEntry.dn},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directory := newTestDirectory(t, tt.entries...)
+			local := newLocalUser(t, "ivan@example.com", "local-secret")
+			repo := &userStore{users: []*mysql.User{local}}
+			authenticator := newTestLDAPAuthenticator(t, directory, repo, tt.configure)
+
+			user, err := authenticator.Authenticate(context.Background(), tt.email, tt.password)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantText != "":
+				if err == nil || errors.Is(err, ErrInvalidCredentials) || !strings.Contains(err.Error(), tt.wantText) {
+					t.Fatalf("Authenticate error = %v, want a %q failure", err, tt.wantText)
+				}
+			case err != nil:
+				t.Fatalf("Authenticate: %v", err)
+			case user.ID != local.ID:
+				t.Fatalf("Authenticate returned user %q, want the local user %q", user.ID, local.ID)
+			}
+
+			if got := directory.boundDNs(); strings.Join(got, "|") != strings.Join(tt.wantBinds, "|") {
+				t.Fatalf("binds = %q, want %q", got, tt.wantBinds)
+			}
+		})
+	}
+}
+
+func TestLDAPAuthenticatorRejectsEmptyPasswordBeforeBind(t *testing.T) {
+	directory := newTestDirectory(t, ivanEntry)
+	repo := &userStore{users: []*mysql.User{newLocalUser(t, "ivan@example.com", "local-secret")}}
+	authenticator := newTestLDAPAuthenticator(t, directory, repo, nil)
+
+	if _, err := authenticator.Authenticate(context.Background(), "ivan@example.com", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate error = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if count := directory.connectionCount(); count != 0 {
+		t.Fatalf("empty password opened %d directory connections, want none", count)
+	}
+}
+
+func TestLDAPAuthenticatorProvisionsUsers(t *testing.T) {
+	directory := newTestDirectory(t, ivanEntry)
+
+	repo := &userStore{}
+	authenticator := newTestLDAPAuthenticator(t, directory, repo, nil)
+	if _, err := authenticator.Authenticate(context.Background(), "ivan@example.com", "directory-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate without provisioning error = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if len(repo.users) != 0 {
+		t.Fatalf("created %d users without provisioning", len(repo.users))
+	}
+
+	authenticator = newTestLDAPAuthenticator(t, directory, repo, func(cfg *LDAPConfig) {
+		cfg.AutoProvision = true
+	})
+	user, err := authenticator.Authenticate(context.Background(), "ivan@example.com", "directory-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if len(repo.users) != 1 {
+		t.Fatalf("created %d users, want 1", len(repo.users))
+	}
+	created := repo.users[0]
+	switch {
+	case created.ID != user.ID || !mysql.ValidID(created.ID):
+		t.Fatalf("provisioned user ID %q, returned %q", created.ID, user.ID)
+	case created.Email != ivanEntry.mail || created.Name != ivanEntry.name:
+		t.Fatalf("provisioned %q <%s>, want the directory name and email", created.Name, created.Email)
+	case created.Role != mysql.RoleMerchandiser:
+		t.Fatalf("provisioned role %q, want %q", created.Role, mysql.RoleMerchandiser)
+	case created.HasPassword() || created.Password != "":
+		t.Fatal("provisioned user has a local password")
+	}
+
+	// The provisioned user is found, not created again, on the next login.
+	if _, err := authenticator.Authenticate(context.Background(), "ivan@example.com", "directory-secret"); err != nil {
+		t.Fatalf("second Authenticate: %v", err)
+	}
+	if len(repo.users) != 1 {
+		t.Fatalf("second login created another user, have %d", len(repo.users))
+	}
+}
+
+func TestChainAuthenticatorFallsBackToLocal(t *testing.T) {
+	directory := newTestDirectory(t, ivanEntry)
+	local := newLocalUser(t, "anna@example.com", "local-secret")
+	repo := &userStore{users: []*mysql.User{local}}
+
+	ldapBackend := newTestLDAPAuthenticator(t, directory, repo, func(cfg *LDAPConfig) {
+		cfg.AutoProvision = true
+	})
+	chain, err := NewChainAuthenticator(ldapBackend, NewLocalAuthenticator(repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, backend, err := chain.Authenticate(context.Background(), "anna@example.com", "local-secret")
+	if err != nil {
+		t.Fatalf("Authenticate local user: %v", err)
+	}
+	if backend != BackendLocal || user.ID != local.ID {
+		t.Fatalf("authenticated %q by %q, want %q by %q", user.ID, backend, local.ID, BackendLocal)
+	}
+
+	if _, _, err := chain.Authenticate(context.Background(), "anna@example.com", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate with a wrong password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+
+	user, backend, err = chain.Authenticate(context.Background(), "ivan@example.com", "directory-secret")
+	if err != nil {
+		t.Fatalf("Authenticate directory user: %v", err)
+	}
+	if backend != BackendLDAP || user.Email != ivanEntry.mail {
+		t.Fatalf("authenticated %q by %q, want the directory user by %q", user.Email, backend, BackendLDAP)
+	}
+
+	// The provisioned user has no local password to fall back to.
+	if _, _, err := chain.Authenticate(context.Background(), "ivan@example.com", "guessed"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate provisioned user with a wrong password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestChainAuthenticatorStopsWhenDirectoryIsUnavailable(t *testing.T) {
+	directory := newTestDirectory(t)
+	local := newLocalUser(t, "anna@example.com", "local-secret")
+	repo := &userStore{users: []*mysql.User{local}}
+
+	ldapBackend := newTestLDAPAuthenticator(t, directory, repo, nil)
+	directory.listener.Close()
+
+	chain, err := NewChainAuthenticator(ldapBackend, NewLocalAuthenticator(repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, backend, err := chain.Authenticate(context.Background(), "anna@example.com", "local-secret")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) || backend != BackendLDAP {
+		t.Fatalf("Authenticate = (%q, %v), want the directory failure", backend, err)
+	}
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Throttle kinds tracked by the login guard.
+const (
+	ThrottleEmail = "email"
+	ThrottleIP    = "ip"
+)
+
+// failureWindow is how long a failed attempt counts towards delays and lockouts.
+const failureWindow = time.Hour
+
+// LockoutConfig controls brute-force protection of the login endpoint. Zero
+// thresholds disable the corresponding lockout.
+type LockoutConfig struct {
+	MaxFailures   int
+	IPMaxFailures int
+	Lockout       time.Duration
+	DelayBase     time.Duration
+	DelayMax      time.Duration
+}
+
+// LoginGuard tracks failed logins per email and per client IP. Emails get
+// progressive delays between attempts; both emails and IPs are locked out
+// temporarily once their threshold is reached.
+type LoginGuard struct {
+	repo Repository
+	cfg  LockoutConfig
+}
+
+// NewLoginGuard constructs a LoginGuard persisting its counters through repo.
+func NewLoginGuard(repo Repository, cfg LockoutConfig) *LoginGuard {
+	return &LoginGuard{repo: repo, cfg: cfg}
+}
+
+// NormalizeEmail lowercases and trims an email address for use as a throttle subject.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Wait returns how long the caller must wait before the next login attempt for
+// the email and IP is allowed. A zero duration means the attempt may proceed.
+func (g *LoginGuard) Wait(ctx context.Context, email, ip string, now time.Time) (time.Duration, error) {
+	var wait time.Duration
+	for _, subject := range g.subjects(email, ip) {
+		throttle, err := g.repo.FindLoginThrottle(ctx, subject.kind, subject.value)
+		if err != nil {
+			return 0, err
+		}
+
+		var until time.Time
+		if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+			until = *throttle.LockedUntil
+		} else if subject.kind == ThrottleEmail && throttle.LastFailureAt != nil && throttle.Failures > 0 {
+			until = throttle.LastFailureAt.Add(g.delay(throttle.Failures))
+		}
+
+		if remaining := until.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+// Fail records a failed login attempt for the email and IP.
+func (g *LoginGuard) Fail(ctx context.Context, email, ip string, now time.Time) error {
+	for _, subject := range g.subjects(email, ip) {
+		lockAfter := g.cfg.MaxFailures
+		if subject.kind == ThrottleIP {
+			lockAfter = g.cfg.IPMaxFailures
+		}
+		if _, err := g.repo.RegisterLoginFailure(ctx, subject.kind, subject.value, now, failureWindow, lockAfter, g.cfg.Lockout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Succeed clears the failure counters of the email and IP after a successful login.
+func (g *LoginGuard) Succeed(ctx context.Context, email, ip string) error {
+	for _, subject := range g.subjects(email, ip) {
+		if err := g.repo.ResetLoginThrottle(ctx, subject.kind, subject.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unlock clears failures and locks of a single subject.
+func (g *LoginGuard) Unlock(ctx context.Context, kind, subject string) error {
+	if kind == ThrottleEmail {
+		subject = NormalizeEmail(subject)
+	}
+	return g.repo.ResetLoginThrottle(ctx, kind, subject)
+}
+
+// delay grows exponentially with the number of consecutive failures.
+func (g *LoginGuard) delay(failures int) time.Duration {
+	if g.cfg.DelayBase <= 0 {
+		return 0
+	}
+	delay := g.cfg.DelayBase
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if g.cfg.DelayMax > 0 && delay >= g.cfg.DelayMax {
+			return g.cfg.DelayMax
+		}
+	}
+	return delay
+}
+
+type throttleSubject struct {
+	kind  string
+	value string
+}
+
+func (g *LoginGuard) subjects(email, ip string) []throttleSubject {
+	subjects := make([]throttleSubject, 0, 2)
+	if email = NormalizeEmail(email); email != "" {
+		subjects = append(subjects, throttleSubject{kind: ThrottleEmail, value: email})
+	}
+	if ip != "" {
+		subjects = append(subjects, throttleSubject{kind: ThrottleIP, value: ip})
+	}
+	return subjects
+}
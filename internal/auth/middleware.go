@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -18,10 +19,21 @@ const (
 	ContextUserKey contextKey = "authenticated_user"
 	// ContextTokenKey stores the active token model in the request context.
 	ContextTokenKey contextKey = "authenticated_token"
+	// ContextAPIKeyKey stores the API key used to authenticate the request, if any.
+	ContextAPIKeyKey contextKey = "authenticated_api_key"
+	// ContextClaimsKey stores the claims of a signed access token, if one was used.
+	ContextClaimsKey contextKey = "authenticated_claims"
+	// ContextActorKey stores the administrator behind an impersonated request.
+	ContextActorKey contextKey = "authenticated_actor"
 )
 
-// TokenAuthMiddleware validates bearer tokens from the Authorization header.
-func TokenAuthMiddleware(repo Repository) gin.HandlerFunc {
+// TokenAuthMiddleware validates bearer tokens from the Authorization header and
+// extends their sliding expiration. Impersonation sessions additionally expose the
+// administrator behind them through CurrentActor. API keys are accepted in place of user tokens
+// and authenticate the request as the key owner. When signed access tokens are
+// enabled they are verified without database queries; the user in the context
+// then only carries the ID and role (see LoadSession).
+func TokenAuthMiddleware(repo Repository, cfg SessionConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -39,6 +51,16 @@ func TokenAuthMiddleware(repo Repository) gin.HandlerFunc {
 			return
 		}
 
+		if IsAPIKey(tokenValue) {
+			authenticateAPIKey(c, repo, tokenValue)
+			return
+		}
+
+		if cfg.Signer != nil && IsSignedToken(tokenValue) {
+			authenticateSigned(c, cfg, tokenValue)
+			return
+		}
+
 		token, user, err := repo.FindUserToken(c.Request.Context(), tokenValue)
 		if err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -48,6 +70,33 @@ func TokenAuthMiddleware(repo Repository) gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		if !user.Active {
+			abortDeactivated(c)
+			return
+		}
+		if token.ImpersonatorID != nil && !setImpersonation(c, user, *token.ImpersonatorID) {
+			return
+		}
+
+		now := time.Now()
+		if needsTouch(token, now) {
+			token.LastUsedAt = &now
+			// Impersonation sessions end at their fixed expiry.
+			if cfg.AccessTTL > 0 && token.ImpersonatorID == nil {
+				token.ExpiresAt = expiryFrom(now, cfg.AccessTTL)
+			}
+			token.IP, token.UserAgent = ClientInfo(c)
+			activity := mysql.TokenActivity{
+				UsedAt:    now,
+				ExpiresAt: token.ExpiresAt,
+				IP:        token.IP,
+				UserAgent: token.UserAgent,
+			}
+			if err := repo.TouchUserToken(c.Request.Context(), token.ID, activity); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
 
 		c.Set(string(ContextUserKey), user)
 		c.Set(string(ContextTokenKey), token)
@@ -56,6 +105,204 @@ func TokenAuthMiddleware(repo Repository) gin.HandlerFunc {
 	}
 }
 
+func authenticateAPIKey(c *gin.Context, repo Repository, value string) {
+	key, user, err := repo.FindAPIKey(c.Request.Context(), value)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired api key"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !user.Active {
+		abortDeactivated(c)
+		return
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= touchInterval {
+		key.LastUsedAt = &now
+		if err := repo.TouchAPIKey(c.Request.Context(), key.ID, now); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.Set(string(ContextUserKey), user)
+	c.Set(string(ContextAPIKeyKey), key)
+
+	c.Next()
+}
+
+func authenticateSigned(c *gin.Context, cfg SessionConfig, value string) {
+	now := time.Now()
+	claims, err := cfg.Signer.Verify(value, now)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+		return
+	}
+
+	revoked, err := cfg.Denylist.Revoked(c.Request.Context(), claims.SessionID, now)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if revoked {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+		return
+	}
+
+	user := &mysql.User{Role: claims.Roles[0]}
+	user.SetID(claims.Subject)
+	if claims.Actor != nil && !setImpersonation(c, user, claims.Actor.Subject) {
+		return
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	token := &mysql.UserToken{UserID: claims.Subject, Token: value, ExpiresAt: &expiresAt}
+	token.SetID(claims.SessionID)
+
+	c.Set(string(ContextUserKey), user)
+	c.Set(string(ContextTokenKey), token)
+	c.Set(string(ContextClaimsKey), claims)
+
+	c.Next()
+}
+
+// abortDeactivated rejects a request made on behalf of a deactivated user.
+// Deactivation revokes the user's sessions, so this mostly catches API keys and
+// lookups that raced with the deactivation.
+func abortDeactivated(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "account is deactivated"})
+}
+
+// LoadSession replaces the identity taken from a signed access token with the
+// stored user and session, for endpoints that read or change them. Requests
+// authenticated otherwise pass through unchanged. It must run after TokenAuthMiddleware.
+func LoadSession(repo Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := CurrentClaims(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		token, err := repo.FindUserTokenByID(c.Request.Context(), claims.SessionID)
+		if err == nil && token.UserID != claims.Subject {
+			err = gorm.ErrRecordNotFound
+		}
+		var user *mysql.User
+		if err == nil {
+			user, err = repo.FindUserByID(c.Request.Context(), claims.Subject)
+		}
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !user.Active {
+			abortDeactivated(c)
+			return
+		}
+
+		current, _ := CurrentToken(c)
+		token.Token = current.Token
+
+		c.Set(string(ContextUserKey), user)
+		c.Set(string(ContextTokenKey), token)
+
+		c.Next()
+	}
+}
+
+// Permitted reports whether the request may use the permission, applying the same
+// rules as RequirePermission. Handlers use it to leave out data, such as search
+// hits, that the caller may not read.
+func Permitted(c *gin.Context, permission Permission) bool {
+	user, ok := CurrentUser(c)
+	if !ok || !Allowed(user.Role, permission) {
+		return false
+	}
+	if key, ok := CurrentAPIKey(c); ok && !ScopesAllow(key.Scopes, permission) {
+		return false
+	}
+	return true
+}
+
+// RequirePermission rejects requests whose authenticated user lacks the permission.
+// Requests authenticated by an API key additionally need a key scope covering it.
+// It must run after TokenAuthMiddleware.
+func RequirePermission(permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := CurrentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		if !Allowed(user.Role, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+
+		if key, ok := CurrentAPIKey(c); ok && !ScopesAllow(key.Scopes, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "api key scope does not allow " + string(permission)})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireUserToken rejects requests that were not authenticated by a user token,
+// such as API key requests to session management endpoints.
+func RequireUserToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := CurrentToken(c); !ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "a user session is required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ScopeMiddleware stores the data scope of the authenticated user in the request
+// context so that repositories only expose rows the user may see. Signed access
+// tokens carry the user's companies; other requests look them up. It must run
+// after TokenAuthMiddleware.
+func ScopeMiddleware(repo Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := CurrentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		var companyIDs []string
+		if user.Role != mysql.RoleAdmin {
+			if claims, ok := CurrentClaims(c); ok && claims.CompanyIDs != nil {
+				companyIDs = claims.CompanyIDs
+			} else {
+				ids, err := repo.FindUserCompanyIDs(c.Request.Context(), user.ID)
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+				companyIDs = ids
+			}
+		}
+
+		scope := ScopeFor(user, companyIDs)
+		c.Request = c.Request.WithContext(mysql.WithScope(c.Request.Context(), scope))
+
+		c.Next()
+	}
+}
+
 // CurrentUser retrieves the authenticated user from the context when available.
 func CurrentUser(c *gin.Context) (*mysql.User, bool) {
 	value, ok := c.Get(string(ContextUserKey))
@@ -65,3 +312,34 @@ func CurrentUser(c *gin.Context) (*mysql.User, bool) {
 	user, ok := value.(*mysql.User)
 	return user, ok
 }
+
+// CurrentToken retrieves the token used to authenticate the request when available.
+func CurrentToken(c *gin.Context) (*mysql.UserToken, bool) {
+	value, ok := c.Get(string(ContextTokenKey))
+	if !ok {
+		return nil, false
+	}
+	token, ok := value.(*mysql.UserToken)
+	return token, ok
+}
+
+// CurrentClaims retrieves the claims of the signed access token used to
+// authenticate the request when available.
+func CurrentClaims(c *gin.Context) (*AccessClaims, bool) {
+	value, ok := c.Get(string(ContextClaimsKey))
+	if !ok {
+		return nil, false
+	}
+	claims, ok := value.(*AccessClaims)
+	return claims, ok
+}
+
+// CurrentAPIKey retrieves the API key used to authenticate the request when available.
+func CurrentAPIKey(c *gin.Context) (*mysql.APIKey, bool) {
+	value, ok := c.Get(string(ContextAPIKeyKey))
+	if !ok {
+		return nil, false
+	}
+	key, ok := value.(*mysql.APIKey)
+	return key, ok
+}
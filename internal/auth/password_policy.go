@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violation codes reported by PasswordPolicy.
+const (
+	PasswordTooShort        = "too_short"
+	PasswordTooLong         = "too_long"
+	PasswordMissingUpper    = "missing_upper"
+	PasswordMissingLower    = "missing_lower"
+	PasswordMissingDigit    = "missing_digit"
+	PasswordMissingSymbol   = "missing_symbol"
+	PasswordCommon          = "common"
+	PasswordContainsProfile = "contains_profile"
+)
+
+// PasswordPolicyConfig describes the requirements for new passwords.
+type PasswordPolicyConfig struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// BlocklistFile names a file of common or breached passwords, one per line.
+	// Lines starting with "#" are ignored.
+	BlocklistFile string
+}
+
+// PasswordViolation is a single unmet password requirement.
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every requirement a rejected password does not meet.
+type PasswordPolicyError struct {
+	Violations []PasswordViolation `json:"violations"`
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return "password does not meet the policy: " + strings.Join(messages, "; ")
+}
+
+// PasswordPolicy validates passwords chosen by users. Lengths are counted in
+// characters, not bytes.
+type PasswordPolicy struct {
+	cfg       PasswordPolicyConfig
+	blocklist map[string]struct{}
+}
+
+// NewPasswordPolicy constructs a PasswordPolicy, loading the blocklist file if configured.
+func NewPasswordPolicy(cfg PasswordPolicyConfig) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{cfg: cfg, blocklist: map[string]struct{}{}}
+	if cfg.BlocklistFile == "" {
+		return policy, nil
+	}
+
+	file, err := os.Open(cfg.BlocklistFile)
+	if err != nil {
+		return nil, fmt.Errorf("open password blocklist: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		policy.blocklist[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read password blocklist: %w", err)
+	}
+	return policy, nil
+}
+
+// Validate checks the password against the policy. Profile values such as the
+// user's email and name must not appear in the password. The returned error is a
+// *PasswordPolicyError when the password is rejected.
+func (p *PasswordPolicy) Validate(password string, profile ...string) error {
+	var violations []PasswordViolation
+	add := func(code, format string, args ...any) {
+		violations = append(violations, PasswordViolation{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if p.cfg.MinLength > 0 && length < p.cfg.MinLength {
+		add(PasswordTooShort, "must be at least %d characters long", p.cfg.MinLength)
+	}
+	if p.cfg.MaxLength > 0 && length > p.cfg.MaxLength {
+		add(PasswordTooLong, "must be at most %d characters long", p.cfg.MaxLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.cfg.RequireUpper && !upper {
+		add(PasswordMissingUpper, "must contain an uppercase letter")
+	}
+	if p.cfg.RequireLower && !lower {
+		add(PasswordMissingLower, "must contain a lowercase letter")
+	}
+	if p.cfg.RequireDigit && !digit {
+		add(PasswordMissingDigit, "must contain a digit")
+	}
+	if p.cfg.RequireSymbol && !symbol {
+		add(PasswordMissingSymbol, "must contain a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+	if _, ok := p.blocklist[lowered]; ok {
+		add(PasswordCommon, "is too common")
+	}
+	for _, value := range profileTerms(profile) {
+		if strings.Contains(lowered, value) {
+			add(PasswordContainsProfile, "must not contain your name or email")
+			break
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// profileTerms splits profile values into lowercase terms long enough to be
+// meaningful inside a password, e.g. the local part of an email address.
+func profileTerms(profile []string) []string {
+	var terms []string
+	for _, value := range profile {
+		value = strings.ToLower(value)
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			value = local
+		}
+		for _, term := range strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if utf8.RuneCountInString(term) >= 4 {
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"strings"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// Action is a verb that can be performed on a resource.
+type Action string
+
+const (
+	ActionRead   Action = "read"
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+	// ActionManage covers administrative operations such as unlocking accounts.
+	ActionManage Action = "manage"
+)
+
+// Permission grants an action on a resource and is written as "resource:action".
+// Either part may be "*" to match any resource or action.
+type Permission string
+
+// NewPermission builds a permission for the given resource and action.
+func NewPermission(resource string, action Action) Permission {
+	return Permission(resource + ":" + string(action))
+}
+
+// Matches reports whether the granted permission covers the required one.
+func (p Permission) Matches(required Permission) bool {
+	grantedResource, grantedAction, _ := strings.Cut(string(p), ":")
+	requiredResource, requiredAction, _ := strings.Cut(string(required), ":")
+
+	return (grantedResource == "*" || grantedResource == requiredResource) &&
+		(grantedAction == "*" || grantedAction == requiredAction)
+}
+
+var catalogResources = []string{"companies", "retail-points", "brands", "categories", "products"}
+
+var rolePermissions = map[mysql.Role][]Permission{
+	mysql.RoleAdmin: {"*:*"},
+	mysql.RoleSupervisor: append(grant(catalogResources, ActionCreate, ActionUpdate, ActionDelete),
+		"*:read",
+		"visits:*",
+		"visit-items:*",
+	),
+	mysql.RoleMerchandiser: append(grant(catalogResources, ActionRead),
+		"users:read",
+		"visits:read", "visits:create", "visits:update",
+		"visit-items:*",
+	),
+	mysql.RoleReadOnly: {"*:read"},
+}
+
+func grant(resources []string, actions ...Action) []Permission {
+	permissions := make([]Permission, 0, len(resources)*len(actions))
+	for _, resource := range resources {
+		for _, action := range actions {
+			permissions = append(permissions, NewPermission(resource, action))
+		}
+	}
+	return permissions
+}
+
+// PermissionsFor returns the permissions granted to the role.
+func PermissionsFor(role mysql.Role) []Permission {
+	return append([]Permission(nil), rolePermissions[role]...)
+}
+
+// Allowed reports whether the role grants the required permission.
+func Allowed(role mysql.Role, required Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted.Matches(required) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeFor derives the data visibility rules for the user. Merchandisers only see
+// their own visits, and non-admin users bound to companies only see those companies.
+func ScopeFor(user *mysql.User, companyIDs []string) mysql.Scope {
+	var scope mysql.Scope
+	if user.Role == mysql.RoleMerchandiser {
+		scope.UserID = user.ID
+	}
+	if user.Role != mysql.RoleAdmin {
+		scope.CompanyIDs = companyIDs
+	}
+	return scope
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+)
+
+// purgeMetrics publishes cumulative purge counts under "token_purge" in expvar.
+var (
+	purgeMetrics = expvar.NewMap("token_purge")
+	purgeLastRun = new(expvar.Int)
+)
+
+func init() {
+	purgeMetrics.Set("last_run_unix", purgeLastRun)
+}
+
+// PurgeConfig controls the background purge of stale tokens.
+type PurgeConfig struct {
+	// Interval is the time between purge passes.
+	Interval time.Duration
+	// IdleTimeout, when positive, also purges sessions unused for that long.
+	IdleTimeout time.Duration
+}
+
+// TokenPurger periodically deletes expired and idle sessions together with
+// other short-lived rows that are otherwise only removed when presented.
+type TokenPurger struct {
+	repo Repository
+	cfg  PurgeConfig
+}
+
+// NewTokenPurger constructs a TokenPurger. Interval must be positive.
+func NewTokenPurger(repo Repository, cfg PurgeConfig) *TokenPurger {
+	return &TokenPurger{repo: repo, cfg: cfg}
+}
+
+// Run purges once right away and then every Interval until ctx is cancelled.
+// A pass in progress is aborted through ctx, so Run returns promptly on shutdown.
+func (p *TokenPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.Purge(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge runs a single pass, logging and publishing the number of rows removed.
+func (p *TokenPurger) Purge(ctx context.Context, now time.Time) {
+	var idleBefore time.Time
+	if p.cfg.IdleTimeout > 0 {
+		idleBefore = now.Add(-p.cfg.IdleTimeout)
+	}
+
+	started := time.Now()
+	result, err := p.repo.PurgeExpired(ctx, now, idleBefore)
+
+	purgeMetrics.Add("runs", 1)
+	purgeMetrics.Add("user_tokens", result.UserTokens)
+	purgeMetrics.Add("revoked_sessions", result.RevokedSessions)
+	purgeMetrics.Add("two_factor_challenges", result.TwoFactorChallenges)
+	purgeMetrics.Add("password_reset_tokens", result.PasswordResetTokens)
+	purgeLastRun.Set(now.Unix())
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		purgeMetrics.Add("failures", 1)
+		log.Printf("token purge failed after removing %d rows: %v", result.Total(), err)
+		return
+	}
+	if result.Total() > 0 {
+		log.Printf("token purge removed %d tokens, %d revoked sessions, %d two-factor challenges and %d password reset tokens in %s",
+			result.UserTokens, result.RevokedSessions, result.TwoFactorChallenges, result.PasswordResetTokens,
+			time.Since(started).Round(time.Millisecond))
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"time"
 
 	"merch-app-codex/internal/storage/mysql"
 )
@@ -9,7 +10,50 @@ import (
 // Repository defines the persistence operations required by the auth package.
 type Repository interface {
 	FindUserByEmail(ctx context.Context, email string) (*mysql.User, error)
+	FindUserByID(ctx context.Context, id string) (*mysql.User, error)
+	FindUserByOIDCSubject(ctx context.Context, subject string) (*mysql.User, error)
+	CreateUser(ctx context.Context, user *mysql.User) error
 	CreateUserToken(ctx context.Context, token *mysql.UserToken) error
 	FindUserToken(ctx context.Context, token string) (*mysql.UserToken, *mysql.User, error)
+	FindUserTokenByRefresh(ctx context.Context, refreshToken string) (*mysql.UserToken, *mysql.User, error)
+	TouchUserToken(ctx context.Context, id string, activity mysql.TokenActivity) error
+	FindUserTokenByID(ctx context.Context, id string) (*mysql.UserToken, error)
+	ListUserTokens(ctx context.Context, userID string) ([]mysql.UserToken, error)
+	DeleteUserTokenByID(ctx context.Context, id string) error
+	RotateUserToken(ctx context.Context, previousID string, token *mysql.UserToken) error
 	DeleteUserToken(ctx context.Context, token string) error
+	DeleteUserTokens(ctx context.Context, userID string) error
+	DeleteOtherUserTokens(ctx context.Context, userID, keepID string) error
+	ListRevokedSessions(ctx context.Context, now time.Time) ([]mysql.RevokedSession, error)
+	OnSessionsRevoked(fn func(sessions []mysql.RevokedSession))
+	PurgeExpired(ctx context.Context, now, idleBefore time.Time) (mysql.PurgeResult, error)
+	UpdateUserColumns(ctx context.Context, user *mysql.User, columns ...string) error
+	SetUserActive(ctx context.Context, id string, active bool, now time.Time, version *time.Time) (*mysql.User, error)
+	FindUserCompanyIDs(ctx context.Context, userID string) ([]string, error)
+	SetUserCompanyIDs(ctx context.Context, userID string, companyIDs []string) error
+	FindLoginThrottle(ctx context.Context, kind, subject string) (*mysql.LoginThrottle, error)
+	RegisterLoginFailure(ctx context.Context, kind, subject string, at time.Time, window time.Duration, lockAfter int, lockFor time.Duration) (*mysql.LoginThrottle, error)
+	ResetLoginThrottle(ctx context.Context, kind, subject string) error
+	CreateSecurityEvent(ctx context.Context, event *mysql.SecurityEvent) error
+	ListSecurityEvents(ctx context.Context, filter mysql.SecurityEventFilter) ([]mysql.SecurityEvent, error)
+	CreatePasswordResetToken(ctx context.Context, token *mysql.PasswordResetToken) error
+	FindPasswordResetToken(ctx context.Context, token string, now time.Time) (*mysql.PasswordResetToken, *mysql.User, error)
+	ResetPassword(ctx context.Context, token, password string, now time.Time) (*mysql.User, error)
+	CreateInvitation(ctx context.Context, user *mysql.User, isNew bool, invitation *mysql.UserInvitation) error
+	ListInvitations(ctx context.Context, filter mysql.InvitationFilter) ([]mysql.UserInvitation, error)
+	FindInvitation(ctx context.Context, token string, now time.Time) (*mysql.UserInvitation, *mysql.User, error)
+	AcceptInvitation(ctx context.Context, token, password string, now time.Time) (*mysql.User, error)
+	RevokeInvitation(ctx context.Context, id string, now time.Time) error
+	CreateAPIKey(ctx context.Context, key *mysql.APIKey) error
+	FindAPIKey(ctx context.Context, key string) (*mysql.APIKey, *mysql.User, error)
+	ListAPIKeys(ctx context.Context) ([]mysql.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+	CreateTwoFactorChallenge(ctx context.Context, challenge *mysql.TwoFactorChallenge) error
+	FindTwoFactorChallenge(ctx context.Context, token string, now time.Time) (*mysql.TwoFactorChallenge, *mysql.User, error)
+	DeleteTwoFactorChallenge(ctx context.Context, id string) error
+	AdvanceTOTPStep(ctx context.Context, userID string, step int64) error
+	ConsumeRecoveryCode(ctx context.Context, user *mysql.User, previous []string) error
+	ListRolePolicies(ctx context.Context) ([]mysql.RolePolicy, error)
+	SaveRolePolicies(ctx context.Context, policies []mysql.RolePolicy) error
 }
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// touchInterval limits how often sliding expiration writes to the database.
+const touchInterval = time.Minute
+
+// userAgentLimit matches the size of the user_agent columns.
+const userAgentLimit = 512
+
+// SessionConfig controls the lifetime of issued tokens. A zero TTL disables expiration.
+type SessionConfig struct {
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	// Signer, when set, replaces opaque access tokens with signed ones that are
+	// verified without database queries. Denylist must be set along with it.
+	Signer   *TokenSigner
+	Denylist *Denylist
+}
+
+// IssueAccessToken replaces the opaque access token of a stored session with a
+// signed one when signed tokens are enabled. The signed token expires with the session's
+// access token, so AccessTTL must be positive in that mode. The user's companies
+// are embedded so that ScopeMiddleware does not have to look them up.
+func (cfg SessionConfig) IssueAccessToken(ctx context.Context, repo Repository, user *mysql.User, token *mysql.UserToken, now time.Time) error {
+	if cfg.Signer == nil {
+		return nil
+	}
+	if token.ExpiresAt == nil {
+		return errors.New("signed access tokens require an access token TTL")
+	}
+
+	var companyIDs []string
+	if user.Role != mysql.RoleAdmin {
+		ids, err := repo.FindUserCompanyIDs(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		companyIDs = ids
+	}
+
+	var actorID string
+	if token.ImpersonatorID != nil {
+		actorID = *token.ImpersonatorID
+	}
+	signed, err := cfg.Signer.Sign(user, companyIDs, token.ID, actorID, now, *token.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	token.Token = signed
+	return nil
+}
+
+// NewUserToken builds an access and refresh token pair for the given user.
+func NewUserToken(userID string, cfg SessionConfig, now time.Time) (*mysql.UserToken, error) {
+	accessValue, err := GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshValue, err := GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	token := &mysql.UserToken{
+		UserID:           userID,
+		Token:            accessValue,
+		RefreshToken:     refreshValue,
+		LastUsedAt:       &now,
+		ExpiresAt:        expiryFrom(now, cfg.AccessTTL),
+		RefreshExpiresAt: expiryFrom(now, cfg.RefreshTTL),
+	}
+	token.SetID(mysql.NewID())
+
+	return token, nil
+}
+
+// needsTouch reports whether the token's usage and sliding expiration should be
+// persisted again. Writes are throttled to one per touchInterval so that busy
+// clients do not cause a database write on every request.
+func needsTouch(token *mysql.UserToken, now time.Time) bool {
+	return token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= touchInterval
+}
+
+func expiryFrom(now time.Time, ttl time.Duration) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+	expiresAt := now.Add(ttl)
+	return &expiresAt
+}
+
+// ClientInfo returns the client IP and user agent of the request, trimmed to fit storage.
+func ClientInfo(c *gin.Context) (ip, userAgent string) {
+	userAgent = c.Request.UserAgent()
+	count := 0
+	for i := range userAgent {
+		if count == userAgentLimit {
+			userAgent = userAgent[:i]
+			break
+		}
+		count++
+	}
+	return c.ClientIP(), userAgent
+}
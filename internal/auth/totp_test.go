@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// rfc6238Secret is the SHA-1 seed of the RFC 6238 test vectors, base32-encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// rfc6238Vectors are the SHA-1 test vectors of RFC 6238, appendix B, cut to the
+// six digits the authenticator apps show.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestVerifyTOTPVectors(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		step, ok := VerifyTOTP(rfc6238Secret, tt.code, 0, time.Unix(tt.unix, 0))
+		if !ok {
+			t.Fatalf("code %s rejected at %d", tt.code, tt.unix)
+		}
+		if want := tt.unix / totpPeriod; step != want {
+			t.Fatalf("code %s matched step %d, want %d", tt.code, step, want)
+		}
+	}
+
+	// Authenticator apps may show the code in groups and the secret in lower case.
+	if _, ok := VerifyTOTP(strings.ToLower(rfc6238Secret), " 287 082 ", 0, time.Unix(59, 0)); !ok {
+		t.Fatal("formatted code rejected")
+	}
+}
+
+func TestVerifyTOTPSkew(t *testing.T) {
+	const unix = 1111111111
+	code := rfc6238Vectors[2].code
+
+	tests := []struct {
+		name   string
+		offset time.Duration
+		want   bool
+	}{
+		{name: "same period", offset: 0, want: true},
+		{name: "one period later", offset: totpPeriod * time.Second, want: true},
+		{name: "one period earlier", offset: -totpPeriod * time.Second, want: true},
+		{name: "two periods later", offset: 2 * totpPeriod * time.Second, want: false},
+		{name: "two periods earlier", offset: -2 * totpPeriod * time.Second, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := VerifyTOTP(rfc6238Secret, code, 0, time.Unix(unix, 0).Add(tt.offset))
+			if ok != tt.want {
+				t.Fatalf("VerifyTOTP = %v, want %v", ok, tt.want)
+			}
+			if ok && step != unix/totpPeriod {
+				t.Fatalf("matched step %d, want the step the code was issued for", step)
+			}
+		})
+	}
+}
+
+func TestVerifyTOTPRejectsReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code := rfc6238Vectors[2].code
+
+	step, ok := VerifyTOTP(rfc6238Secret, code, 0, now)
+	if !ok {
+		t.Fatal("code rejected on first use")
+	}
+	if _, ok := VerifyTOTP(rfc6238Secret, code, step, now); ok {
+		t.Fatal("code accepted again at the stored step")
+	}
+	// A code from an earlier step is no longer accepted once a later one was used.
+	if _, ok := VerifyTOTP(rfc6238Secret, code, step+1, now.Add(totpPeriod*time.Second)); ok {
+		t.Fatal("earlier code accepted after a later step was used")
+	}
+}
+
+func TestVerifyTOTPRejectsMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, tt := range []struct{ secret, code string }{
+		{rfc6238Secret, "94287082"},
+		{rfc6238Secret, "28708"},
+		{rfc6238Secret, ""},
+		{"not base32!", "287082"},
+	} {
+		if _, ok := VerifyTOTP(tt.secret, tt.code, 0, now); ok {
+			t.Fatalf("VerifyTOTP(%q, %q) accepted", tt.secret, tt.code)
+		}
+	}
+}
+
+func TestConsumeRecoveryCode(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("issued %d codes and %d digests, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+	for i, code := range codes {
+		if slices.Contains(hashes, code) || slices.Index(codes, code) != i {
+			t.Fatalf("code %q is stored in plain text or repeated", code)
+		}
+	}
+
+	user := &mysql.User{TOTPRecoveryCodes: hashes}
+	previous := user.TOTPRecoveryCodes
+
+	// Codes are accepted regardless of case, dashes and spaces.
+	typed := strings.ToUpper(strings.ReplaceAll(codes[3], "-", " "))
+	if !ConsumeRecoveryCode(user, typed) {
+		t.Fatalf("recovery code %q rejected", typed)
+	}
+	if len(user.TOTPRecoveryCodes) != recoveryCodeCount-1 {
+		t.Fatalf("%d codes left, want %d", len(user.TOTPRecoveryCodes), recoveryCodeCount-1)
+	}
+	if ConsumeRecoveryCode(user, codes[3]) {
+		t.Fatal("recovery code accepted twice")
+	}
+	// The stored list guards the conditional update, so it must stay intact.
+	if !slices.Equal(previous, hashes) || len(previous) != recoveryCodeCount {
+		t.Fatal("consuming a code changed the previous list of codes")
+	}
+
+	if !ConsumeRecoveryCode(user, codes[0]) || !ConsumeRecoveryCode(user, codes[9]) {
+		t.Fatal("remaining recovery codes rejected")
+	}
+	if ConsumeRecoveryCode(user, "00000-00000") {
+		t.Fatal("unknown recovery code accepted")
+	}
+}
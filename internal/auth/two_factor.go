@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// policyCacheTTL bounds how long role policies are served from memory.
+const policyCacheTTL = time.Minute
+
+// NewTwoFactorChallenge issues the short-lived challenge returned by a password
+// login of a user with two-factor authentication enabled.
+func NewTwoFactorChallenge(userID, deviceLabel string, ttl time.Duration, now time.Time) (*mysql.TwoFactorChallenge, error) {
+	value, err := GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := &mysql.TwoFactorChallenge{
+		UserID:      userID,
+		Token:       value,
+		DeviceLabel: deviceLabel,
+		ExpiresAt:   now.Add(ttl),
+	}
+	challenge.SetID(mysql.NewID())
+	return challenge, nil
+}
+
+// TwoFactorPolicy tells which roles must enroll in two-factor authentication.
+// Policies are cached briefly because they are consulted on every request.
+type TwoFactorPolicy struct {
+	repo Repository
+
+	mu       sync.Mutex
+	required map[mysql.Role]bool
+	loadedAt time.Time
+}
+
+// NewTwoFactorPolicy constructs a TwoFactorPolicy reading role policies through repo.
+func NewTwoFactorPolicy(repo Repository) *TwoFactorPolicy {
+	return &TwoFactorPolicy{repo: repo}
+}
+
+// Required reports whether users of the role must have two-factor authentication enabled.
+func (p *TwoFactorPolicy) Required(ctx context.Context, role mysql.Role) (bool, error) {
+	required, err := p.load(ctx)
+	if err != nil {
+		return false, err
+	}
+	return required[role], nil
+}
+
+// RequiredRoles lists the roles that must have two-factor authentication enabled.
+func (p *TwoFactorPolicy) RequiredRoles(ctx context.Context) ([]mysql.Role, error) {
+	required, err := p.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]mysql.Role, 0, len(required))
+	for role, enabled := range required {
+		if enabled {
+			roles = append(roles, role)
+		}
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i] < roles[j] })
+	return roles, nil
+}
+
+// SetRequiredRoles requires two-factor authentication for exactly the given roles.
+func (p *TwoFactorPolicy) SetRequiredRoles(ctx context.Context, roles []mysql.Role) error {
+	required := make(map[mysql.Role]bool, len(roles))
+	for _, role := range roles {
+		required[role] = true
+	}
+
+	policies := make([]mysql.RolePolicy, 0, len(mysql.Roles))
+	for _, role := range mysql.Roles {
+		policies = append(policies, mysql.RolePolicy{Role: role, RequireTwoFactor: required[role]})
+	}
+	if err := p.repo.SaveRolePolicies(ctx, policies); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.required = required
+	p.loadedAt = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *TwoFactorPolicy) load(ctx context.Context) (map[mysql.Role]bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.required != nil && time.Since(p.loadedAt) < policyCacheTTL {
+		return p.required, nil
+	}
+
+	policies, err := p.repo.ListRolePolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	required := make(map[mysql.Role]bool, len(policies))
+	for _, policy := range policies {
+		if policy.RequireTwoFactor {
+			required[policy.Role] = true
+		}
+	}
+	p.required = required
+	p.loadedAt = time.Now()
+	return required, nil
+}
+
+// RequireTwoFactorEnrollment rejects user sessions of roles that must use
+// two-factor authentication until the user has enrolled. API keys are exempt
+// because they are not interactive logins. It must run after TokenAuthMiddleware.
+func RequireTwoFactorEnrollment(policy *TwoFactorPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := CurrentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		enrolled := user.TwoFactorEnabled()
+		if claims, ok := CurrentClaims(c); ok {
+			enrolled = claims.TwoFactor
+		}
+
+		if _, isKey := CurrentAPIKey(c); !isKey && !enrolled {
+			required, err := policy.Required(c.Request.Context(), user.Role)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if required {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error": "two-factor authentication enrollment required",
+					"code":  "two_factor_enrollment_required",
+				})
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// policyStore is a Repository keeping role policies in memory.
+type policyStore struct {
+	Repository
+	policies []mysql.RolePolicy
+	queries  int
+}
+
+func (s *policyStore) ListRolePolicies(ctx context.Context) ([]mysql.RolePolicy, error) {
+	s.queries++
+	return s.policies, nil
+}
+
+func (s *policyStore) SaveRolePolicies(ctx context.Context, policies []mysql.RolePolicy) error {
+	s.policies = policies
+	return nil
+}
+
+func TestTwoFactorPolicyRequired(t *testing.T) {
+	store := &policyStore{policies: []mysql.RolePolicy{
+		{Role: mysql.RoleAdmin, RequireTwoFactor: true},
+		{Role: mysql.RoleSupervisor, RequireTwoFactor: false},
+	}}
+	policy := NewTwoFactorPolicy(store)
+	ctx := context.Background()
+
+	for role, want := range map[mysql.Role]bool{
+		mysql.RoleAdmin:        true,
+		mysql.RoleSupervisor:   false,
+		mysql.RoleMerchandiser: false,
+	} {
+		required, err := policy.Required(ctx, role)
+		if err != nil {
+			t.Fatalf("Required: %v", err)
+		}
+		if required != want {
+			t.Fatalf("Required(%s) = %v, want %v", role, required, want)
+		}
+	}
+	if store.queries != 1 {
+		t.Fatalf("policies loaded %d times, want once while cached", store.queries)
+	}
+
+	if err := policy.SetRequiredRoles(ctx, []mysql.Role{mysql.RoleSupervisor, mysql.RoleMerchandiser}); err != nil {
+		t.Fatalf("SetRequiredRoles: %v", err)
+	}
+	if len(store.policies) != len(mysql.Roles) {
+		t.Fatalf("saved %d policies, want one per role", len(store.policies))
+	}
+	roles, err := policy.RequiredRoles(ctx)
+	if err != nil {
+		t.Fatalf("RequiredRoles: %v", err)
+	}
+	if want := []mysql.Role{mysql.RoleMerchandiser, mysql.RoleSupervisor}; !slices.Equal(roles, want) {
+		t.Fatalf("required roles %v, want %v", roles, want)
+	}
+	if required, _ := policy.Required(ctx, mysql.RoleAdmin); required {
+		t.Fatal("admin still required after the policy changed")
+	}
+	if store.queries != 1 {
+		t.Fatalf("saved policies reloaded: %d queries", store.queries)
+	}
+}
+
+func TestRequireTwoFactorEnrollment(t *testing.T) {
+	store := &policyStore{policies: []mysql.RolePolicy{{Role: mysql.RoleSupervisor, RequireTwoFactor: true}}}
+	policy := NewTwoFactorPolicy(store)
+
+	secret := "JBSWY3DPEHPK3PXP"
+	enabledAt := time.Now()
+	enrolled := &mysql.User{Role: mysql.RoleSupervisor, TOTPSecret: &secret, TOTPEnabledAt: &enabledAt}
+
+	tests := []struct {
+		name   string
+		user   *mysql.User
+		claims *AccessClaims
+		apiKey bool
+		want   int
+	}{
+		{name: "role without policy", user: &mysql.User{Role: mysql.RoleMerchandiser}, want: http.StatusOK},
+		{name: "not enrolled", user: &mysql.User{Role: mysql.RoleSupervisor}, want: http.StatusForbidden},
+		{name: "enrolled", user: enrolled, want: http.StatusOK},
+		{name: "signed token of enrolled user", user: &mysql.User{Role: mysql.RoleSupervisor}, claims: &AccessClaims{TwoFactor: true}, want: http.StatusOK},
+		{name: "signed token issued before enrollment", user: enrolled, claims: &AccessClaims{}, want: http.StatusForbidden},
+		{name: "api key", user: &mysql.User{Role: mysql.RoleSupervisor}, apiKey: true, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/visits", nil)
+			c.Set(string(ContextUserKey), tt.user)
+			if tt.claims != nil {
+				c.Set(string(ContextClaimsKey), tt.claims)
+			}
+			if tt.apiKey {
+				c.Set(string(ContextAPIKeyKey), &mysql.APIKey{})
+			}
+
+			RequireTwoFactorEnrollment(policy)(c)
+			if got := c.Writer.Status(); got != tt.want {
+				t.Fatalf("status %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,31 +3,150 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config contains application level configuration values loaded from environment variables.
 type Config struct {
-	Port           string
-	MySQLHost      string
-	MySQLPort      string
-	MySQLUser      string
-	MySQLPassword  string
-	MySQLDatabase  string
-	MigrationsPath string
-	StaticDir      string
+	Port               string
+	MySQLHost          string
+	MySQLPort          string
+	MySQLUser          string
+	MySQLPassword      string
+	MySQLDatabase      string
+	MigrationsPath     string
+	StaticDir          string
+	AccessTokenTTL     time.Duration
+	RefreshTokenTTL    time.Duration
+	LoginMaxFailures   int
+	LoginIPMaxFailures int
+	LoginLockout       time.Duration
+	LoginDelayBase     time.Duration
+	LoginDelayMax      time.Duration
+	AppURL             string
+	PasswordResetTTL   time.Duration
+	InvitationTTL      time.Duration
+	ImpersonationTTL   time.Duration
+	MailDriver         string
+	MailFrom           string
+	MailDir            string
+	SMTPHost           string
+	SMTPPort           string
+	SMTPUsername       string
+	SMTPPassword       string
+	OIDCIssuerURL      string
+	OIDCClientID       string
+	OIDCClientSecret   string
+	OIDCRedirectURL    string
+	OIDCScopes         []string
+	OIDCAutoProvision  bool
+	OIDCDefaultRole    string
+	TOTPIssuer         string
+	TwoFactorTTL       time.Duration
+	PasswordMinLength  int
+	PasswordMaxLength  int
+	PasswordUpper      bool
+	PasswordLower      bool
+	PasswordDigit      bool
+	PasswordSymbol     bool
+	PasswordBlocklist  string
+	TokenMode          string
+	TokenSigningKeys   string
+	SignedTokenTTL     time.Duration
+	DenylistRefresh    time.Duration
+	TokenCacheSize     int
+	TokenCacheTTL      time.Duration
+	TokenPurgeInterval time.Duration
+	TokenIdleTimeout   time.Duration
+	MetricsAddr        string
+	ShutdownTimeout    time.Duration
+	AuthBackends       []string
+	LDAPURL            string
+	LDAPStartTLS       bool
+	LDAPBindDN         string
+	LDAPBindPassword   string
+	LDAPBaseDN         string
+	LDAPUserFilter     string
+	LDAPEmailAttribute string
+	LDAPNameAttribute  string
+	LDAPAutoProvision  bool
+	LDAPDefaultRole    string
+	LDAPTimeout        time.Duration
 }
 
 // Load reads configuration from environment variables and applies sensible defaults.
 func Load() Config {
 	cfg := Config{
-		Port:           getEnv("PORT", "8080"),
-		MySQLHost:      getEnv("MYSQL_HOST", "127.0.0.1"),
-		MySQLPort:      getEnv("MYSQL_PORT", "3306"),
-		MySQLUser:      getEnv("MYSQL_USER", "root"),
-		MySQLPassword:  os.Getenv("MYSQL_PASSWORD"),
-		MySQLDatabase:  getEnv("MYSQL_DATABASE", "merch"),
-		MigrationsPath: getEnv("MIGRATIONS_PATH", "db/migrations"),
-		StaticDir:      getEnv("STATIC_DIR", "web/dist"),
+		Port:               getEnv("PORT", "8080"),
+		MySQLHost:          getEnv("MYSQL_HOST", "127.0.0.1"),
+		MySQLPort:          getEnv("MYSQL_PORT", "3306"),
+		MySQLUser:          getEnv("MYSQL_USER", "root"),
+		MySQLPassword:      os.Getenv("MYSQL_PASSWORD"),
+		MySQLDatabase:      getEnv("MYSQL_DATABASE", "merch"),
+		MigrationsPath:     getEnv("MIGRATIONS_PATH", "db/migrations"),
+		StaticDir:          getEnv("STATIC_DIR", "web/dist"),
+		AccessTokenTTL:     getDuration("ACCESS_TOKEN_TTL", 12*time.Hour),
+		RefreshTokenTTL:    getDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		LoginMaxFailures:   getInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures: getInt("LOGIN_IP_MAX_FAILURES", 50),
+		LoginLockout:       getDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		LoginDelayBase:     getDuration("LOGIN_DELAY_BASE", time.Second),
+		LoginDelayMax:      getDuration("LOGIN_DELAY_MAX", 30*time.Second),
+		AppURL:             getEnv("APP_URL", "http://localhost:8080"),
+		PasswordResetTTL:   getDuration("PASSWORD_RESET_TTL", time.Hour),
+		InvitationTTL:      getDuration("INVITATION_TTL", 72*time.Hour),
+		ImpersonationTTL:   getDuration("IMPERSONATION_TTL", 30*time.Minute),
+		MailDriver:         getEnv("MAIL_DRIVER", "log"),
+		MailFrom:           getEnv("MAIL_FROM", "no-reply@example.com"),
+		MailDir:            getEnv("MAIL_DIR", "var/mail"),
+		SMTPHost:           os.Getenv("SMTP_HOST"),
+		SMTPPort:           getEnv("SMTP_PORT", "587"),
+		SMTPUsername:       os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:       os.Getenv("SMTP_PASSWORD"),
+		OIDCIssuerURL:      os.Getenv("OIDC_ISSUER_URL"),
+		OIDCClientID:       os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret:   os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:    os.Getenv("OIDC_REDIRECT_URL"),
+		OIDCScopes:         strings.Fields(getEnv("OIDC_SCOPES", "openid email profile")),
+		OIDCAutoProvision:  getBool("OIDC_AUTO_PROVISION", false),
+		OIDCDefaultRole:    getEnv("OIDC_DEFAULT_ROLE", "merchandiser"),
+		TOTPIssuer:         getEnv("TOTP_ISSUER", "Merch App"),
+		TwoFactorTTL:       getDuration("TWO_FACTOR_CHALLENGE_TTL", 5*time.Minute),
+		PasswordMinLength:  getInt("PASSWORD_MIN_LENGTH", 10),
+		PasswordMaxLength:  getInt("PASSWORD_MAX_LENGTH", 128),
+		PasswordUpper:      getBool("PASSWORD_REQUIRE_UPPER", false),
+		PasswordLower:      getBool("PASSWORD_REQUIRE_LOWER", false),
+		PasswordDigit:      getBool("PASSWORD_REQUIRE_DIGIT", false),
+		PasswordSymbol:     getBool("PASSWORD_REQUIRE_SYMBOL", false),
+		PasswordBlocklist:  os.Getenv("PASSWORD_BLOCKLIST_FILE"),
+		TokenMode:          getEnv("AUTH_TOKEN_MODE", "opaque"),
+		TokenSigningKeys:   os.Getenv("TOKEN_SIGNING_KEYS"),
+		SignedTokenTTL:     getDuration("SIGNED_TOKEN_TTL", 15*time.Minute),
+		DenylistRefresh:    getDuration("TOKEN_DENYLIST_REFRESH", 10*time.Second),
+		TokenCacheSize:     getInt("TOKEN_CACHE_SIZE", 10000),
+		TokenCacheTTL:      getDuration("TOKEN_CACHE_TTL", 30*time.Second),
+		TokenPurgeInterval: getDuration("TOKEN_PURGE_INTERVAL", time.Hour),
+		TokenIdleTimeout:   getDuration("TOKEN_IDLE_TIMEOUT", 0),
+		MetricsAddr:        os.Getenv("METRICS_ADDR"),
+		ShutdownTimeout:    getDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+		AuthBackends:       strings.Fields(strings.ReplaceAll(getEnv("AUTH_BACKENDS", "local"), ",", " ")),
+		LDAPURL:            os.Getenv("LDAP_URL"),
+		LDAPStartTLS:       getBool("LDAP_START_TLS", false),
+		LDAPBindDN:         os.Getenv("LDAP_BIND_DN"),
+		LDAPBindPassword:   os.Getenv("LDAP_BIND_PASSWORD"),
+		LDAPBaseDN:         os.Getenv("LDAP_BASE_DN"),
+		LDAPUserFilter:     getEnv("LDAP_USER_FILTER", "(&(objectClass=person)(mail=%s))"),
+		LDAPEmailAttribute: getEnv("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		LDAPNameAttribute:  getEnv("LDAP_NAME_ATTRIBUTE", "cn"),
+		LDAPAutoProvision:  getBool("LDAP_AUTO_PROVISION", false),
+		LDAPDefaultRole:    getEnv("LDAP_DEFAULT_ROLE", "merchandiser"),
+		LDAPTimeout:        getDuration("LDAP_TIMEOUT", 5*time.Second),
+	}
+
+	if cfg.OIDCRedirectURL == "" {
+		cfg.OIDCRedirectURL = strings.TrimRight(cfg.AppURL, "/") + "/api/auth/oidc/callback"
 	}
 
 	return cfg
@@ -54,3 +173,39 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
+func getInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
+func getBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
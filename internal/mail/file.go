@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileMailer is a development mailer. It writes every message as an .eml file
+// into a directory, or to the standard logger when no directory is configured.
+type FileMailer struct {
+	from string
+	dir  string
+}
+
+// NewFileMailer constructs a FileMailer writing into dir, or logging when dir is empty.
+func NewFileMailer(from, dir string) *FileMailer {
+	return &FileMailer{from: from, dir: dir}
+}
+
+// Send stores or logs the message.
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if m.dir == "" {
+		log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	now := time.Now()
+	body, err := render(m.from, msg, now)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(msg.To)
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102T150405.000000000"), recipient)
+	return os.WriteFile(filepath.Join(m.dir, name), body, 0o644)
+}
@@ -0,0 +1,49 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer sends messages through an SMTP relay.
+type SMTPMailer struct {
+	addr string
+	host string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer constructs a mailer for the relay described by cfg. PLAIN
+// authentication is used when a username is configured.
+func NewSMTPMailer(cfg Config) *SMTPMailer {
+	port := cfg.SMTPPort
+	if port == "" {
+		port = "587"
+	}
+
+	mailer := &SMTPMailer{
+		addr: net.JoinHostPort(cfg.SMTPHost, port),
+		host: cfg.SMTPHost,
+		from: cfg.From,
+	}
+	if cfg.SMTPUsername != "" {
+		mailer.auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	return mailer
+}
+
+// Send delivers the message. net/smtp does not support cancellation, so the
+// context is only checked before connecting.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	body, err := render(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, body)
+}
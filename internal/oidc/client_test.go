@@ -0,0 +1,414 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "merch-app"
+	testClientSecret = "client-secret"
+	testRedirectURL  = "https://merch.example.com/api/auth/oidc/callback"
+	testCode         = "authorization-code"
+	testNonce        = "nonce-value"
+)
+
+var testNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// testProvider is an in-process OpenID Connect provider serving discovery, the
+// token endpoint and a key set that tests may rotate.
+type testProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	issuer string
+
+	mu             sync.Mutex
+	keys           []jsonWebKey
+	jwksHits       int
+	discoveryHits  int
+	codeChallenge  string
+	idToken        string
+	tokenRequests  []url.Values
+	tokenRejection string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+	p := &testProvider{t: t}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.discoveryHits++
+		issuer := p.issuer
+		p.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": p.server.URL + "/authorize?tenant=test",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+			return
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.tokenRequests = append(p.tokenRequests, r.PostForm)
+
+		switch {
+		case r.PostForm.Get("grant_type") != "authorization_code",
+			r.PostForm.Get("code") != testCode,
+			r.PostForm.Get("client_id") != testClientID,
+			r.PostForm.Get("client_secret") != testClientSecret,
+			r.PostForm.Get("redirect_uri") != testRedirectURL:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		case CodeChallenge(r.PostForm.Get("code_verifier")) != p.codeChallenge:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		default:
+			writeJSON(w, http.StatusOK, map[string]string{
+				"access_token": "access-token",
+				"id_token":     p.idToken,
+				"token_type":   "Bearer",
+			})
+		}
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.jwksHits++
+		keys := p.keys
+		p.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+	})
+
+	p.server = httptest.NewServer(mux)
+	p.issuer = p.server.URL
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *testProvider) client() *Client {
+	client := NewClient(Config{
+		IssuerURL:    p.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	}, p.server.Client())
+	client.now = func() time.Time { return testNow }
+	return client
+}
+
+func (p *testProvider) publish(keys ...jsonWebKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+}
+
+func (p *testProvider) hits() (discovery, jwks int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.discoveryHits, p.jwksHits
+}
+
+func (p *testProvider) claims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            p.issuer,
+		"sub":            "provider-subject",
+		"aud":            testClientID,
+		"exp":            testNow.Add(5 * time.Minute).Unix(),
+		"iat":            testNow.Unix(),
+		"nonce":          testNonce,
+		"email":          "ivan@example.com",
+		"email_verified": true,
+		"name":           "Ivan Petrov",
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func rsaJWK(keyID string, key *rsa.PrivateKey) jsonWebKey {
+	return jsonWebKey{
+		KeyType: "RSA",
+		KeyID:   keyID,
+		Use:     "sig",
+		N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(keyID string, key *ecdsa.PrivateKey) jsonWebKey {
+	return jsonWebKey{
+		KeyType: "EC",
+		KeyID:   keyID,
+		Curve:   "P-256",
+		X:       base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:       base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// signToken builds a compact JWS over the claims with an RSA or ECDSA key.
+func signToken(t *testing.T, keyID string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+
+	algorithm := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		algorithm = "ES256"
+	}
+	header, err := json.Marshal(map[string]string{"alg": algorithm, "kid": keyID, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthCodeURLUsesDiscoveredEndpoint(t *testing.T) {
+	provider := newTestProvider(t)
+	client := provider.client()
+
+	challenge := CodeChallenge("verifier")
+	for i := 0; i < 2; i++ {
+		raw, err := client.AuthCodeURL(context.Background(), "state-value", testNonce, challenge)
+		if err != nil {
+			t.Fatalf("AuthCodeURL: %v", err)
+		}
+		if !strings.HasPrefix(raw, provider.server.URL+"/authorize?tenant=test&") {
+			t.Fatalf("AuthCodeURL = %q, want the discovered endpoint with its query kept", raw)
+		}
+
+		parsed, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := parsed.Query()
+		want := map[string]string{
+			"response_type":         "code",
+			"client_id":             testClientID,
+			"redirect_uri":          testRedirectURL,
+			"scope":                 "openid email profile",
+			"state":                 "state-value",
+			"nonce":                 testNonce,
+			"code_challenge":        challenge,
+			"code_challenge_method": "S256",
+		}
+		for name, value := range want {
+			if got := query.Get(name); got != value {
+				t.Errorf("%s = %q, want %q", name, got, value)
+			}
+		}
+	}
+
+	if discovery, _ := provider.hits(); discovery != 1 {
+		t.Fatalf("discovery fetched %d times, want 1", discovery)
+	}
+}
+
+func TestDiscoveryRejectsForeignIssuer(t *testing.T) {
+	provider := newTestProvider(t)
+	provider.issuer = "https://attacker.example.com"
+
+	_, err := provider.client().AuthCodeURL(context.Background(), "state", testNonce, "challenge")
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("AuthCodeURL error = %v, want issuer mismatch", err)
+	}
+}
+
+func TestExchangeSendsPKCEVerifier(t *testing.T) {
+	provider := newTestProvider(t)
+	client := provider.client()
+
+	verifier, err := RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.codeChallenge = CodeChallenge(verifier)
+	provider.idToken = "header.payload.signature"
+
+	tokens, err := client.Exchange(context.Background(), testCode, verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if tokens.IDToken != provider.idToken || tokens.AccessToken != "access-token" {
+		t.Fatalf("Exchange returned %+v", tokens)
+	}
+
+	if _, err := client.Exchange(context.Background(), testCode, verifier+"x"); err == nil {
+		t.Fatal("Exchange with a wrong verifier succeeded")
+	} else if !strings.Contains(err.Error(), "PKCE verification failed") {
+		t.Fatalf("Exchange error = %v, want the provider's PKCE rejection", err)
+	}
+
+	provider.idToken = ""
+	if _, err := client.Exchange(context.Background(), testCode, verifier); err == nil || !strings.Contains(err.Error(), "no id_token") {
+		t.Fatalf("Exchange error = %v, want missing id_token", err)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	provider := newTestProvider(t)
+	rsaKey, ecKey, otherKey := newRSAKey(t), newECKey(t), newRSAKey(t)
+	provider.publish(rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey))
+
+	tests := []struct {
+		name    string
+		keyID   string
+		key     crypto.Signer
+		modify  func(map[string]interface{})
+		wantErr string
+	}{
+		{name: "rs256", keyID: "rsa-1", key: rsaKey},
+		{name: "es256", keyID: "ec-1", key: ecKey},
+		{name: "audience list", keyID: "rsa-1", key: rsaKey, modify: func(c map[string]interface{}) {
+			c["aud"] = []string{"another-client", testClientID}
+		}},
+		{name: "expired within clock skew", keyID: "rsa-1", key: rsaKey, modify: func(c map[string]interface{}) {
+			c["exp"] = testNow.Add(-30 * time.Second).Unix()
+		}},
+		{name: "bad signature", keyID: "rsa-1", key: otherKey, wantErr: "invalid signature"},
+		{name: "algorithm of another key", keyID: "ec-1", key: rsaKey, wantErr: "does not match RS256"},
+		{name: "wrong audience", keyID: "rsa-1", key: rsaKey, modify: func(c map[string]interface{}) {
+			c["aud"] = "another-client"
+		}, wantErr: "not issued for this client"},
+		{name: "wrong issuer", keyID: "rsa-1", key: rsaKey, modify: func(c map[string]interface{}) {
+			c["iss"] = "https://attacker.example.com"
+		}, wantErr: "unexpected issuer"},
+		{name: "nonce mismatch", keyID: "rsa-1", key: rsaKey, modify: func(c map[string]interface{}) {
+			c["nonce"] = "replayed-nonce"
+		}, wantErr: "nonce mismatch"},
+		{name: "expired", keyID: "rsa-1", key: rsaKey, modify: func(c map[string]interface{}) {
+			c["exp"] = testNow.Add(-2 * time.Minute).Unix()
+		}, wantErr: "expired"},
+		{name: "issued in the future", keyID: "rsa-1", key: rsaKey, modify: func(c map[string]interface{}) {
+			c["iat"] = testNow.Add(2 * time.Minute).Unix()
+		}, wantErr: "issued in the future"},
+		{name: "missing subject", keyID: "rsa-1", key: rsaKey, modify: func(c map[string]interface{}) {
+			delete(c, "sub")
+		}, wantErr: "missing subject"},
+	}
+
+	client := provider.client()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := provider.claims()
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			raw := signToken(t, tt.keyID, tt.key, claims)
+
+			got, err := client.VerifyIDToken(context.Background(), raw, testNonce)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("VerifyIDToken error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyIDToken: %v", err)
+			}
+			if got.Subject != "provider-subject" || got.Email != "ivan@example.com" || !got.EmailVerified {
+				t.Fatalf("VerifyIDToken claims = %+v", got)
+			}
+		})
+	}
+
+	if _, jwks := provider.hits(); jwks != 1 {
+		t.Fatalf("key set fetched %d times, want 1", jwks)
+	}
+}
+
+func TestVerifyIDTokenRefreshesKeysForUnknownKeyID(t *testing.T) {
+	provider := newTestProvider(t)
+	client := provider.client()
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+	provider.publish(rsaJWK("old", oldKey))
+
+	verify := func(keyID string, key crypto.Signer) error {
+		_, err := client.VerifyIDToken(context.Background(), signToken(t, keyID, key, provider.claims()), testNonce)
+		return err
+	}
+
+	if err := verify("old", oldKey); err != nil {
+		t.Fatalf("VerifyIDToken with the initial key: %v", err)
+	}
+
+	// The provider rotates its key; the client learns about it on first use.
+	provider.publish(rsaJWK("new", newKey))
+	if err := verify("new", newKey); err != nil {
+		t.Fatalf("VerifyIDToken with the rotated key: %v", err)
+	}
+	if err := verify("new", newKey); err != nil {
+		t.Fatalf("VerifyIDToken with the cached rotated key: %v", err)
+	}
+	if _, jwks := provider.hits(); jwks != 2 {
+		t.Fatalf("key set fetched %d times, want 2", jwks)
+	}
+
+	if err := verify("unknown", newKey); err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Fatalf("VerifyIDToken error = %v, want unknown signing key", err)
+	}
+	if _, jwks := provider.hits(); jwks != 3 {
+		t.Fatalf("key set fetched %d times, want one refresh per unknown key ID", jwks)
+	}
+	if err := verify("old", oldKey); err == nil {
+		t.Fatal("VerifyIDToken accepted a key the provider no longer publishes")
+	}
+}
@@ -25,15 +25,25 @@ type CompanyVisitSummary struct {
 	TotalAmount float64 `json:"total_amount"`
 }
 
-// CompanyVisitSummary gathers visit statistics for the provided company ID.
+// CompanyVisitSummary gathers visit statistics for the provided company ID within the
+// data scope carried by the context.
 func (s *Service) CompanyVisitSummary(ctx context.Context, companyID string) (CompanyVisitSummary, error) {
 	summary := CompanyVisitSummary{CompanyID: companyID}
 
-	if err := s.repo.DB().WithContext(ctx).
+	scope := mysql.ScopeFromContext(ctx)
+	if !scope.HasCompany(companyID) {
+		return summary, mysql.ErrOutOfScope
+	}
+
+	visits := s.repo.DB().WithContext(ctx).
 		Model(&mysql.Visit{}).
 		Joins("JOIN retail_points ON retail_points.id = visits.retail_point_id").
-		Where("retail_points.company_id = ?", companyID).
-		Count(&summary.TotalVisits).Error; err != nil {
+		Where("retail_points.company_id = ?", companyID)
+	if scope.UserID != "" {
+		visits = visits.Where("visits.user_id = ?", scope.UserID)
+	}
+
+	if err := visits.Count(&summary.TotalVisits).Error; err != nil {
 		return summary, err
 	}
 
@@ -42,13 +52,17 @@ func (s *Service) CompanyVisitSummary(ctx context.Context, companyID string) (Co
 		TotalAmount sql.NullFloat64
 	}
 
-	if err := s.repo.DB().WithContext(ctx).
+	items := s.repo.DB().WithContext(ctx).
 		Model(&mysql.VisitItem{}).
 		Select("COALESCE(SUM(visit_items.present_quantity), 0) AS total_items, COALESCE(SUM(visit_items.present_quantity * visit_items.price), 0) AS total_amount").
 		Joins("JOIN visits ON visits.id = visit_items.visit_id").
 		Joins("JOIN retail_points ON retail_points.id = visits.retail_point_id").
-		Where("retail_points.company_id = ?", companyID).
-		Scan(&aggregate).Error; err != nil {
+		Where("retail_points.company_id = ?", companyID)
+	if scope.UserID != "" {
+		items = items.Where("visits.user_id = ?", scope.UserID)
+	}
+
+	if err := items.Scan(&aggregate).Error; err != nil {
 		return summary, err
 	}
 
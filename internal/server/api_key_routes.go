@@ -0,0 +1,91 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"merch-app-codex/internal/auth"
+)
+
+func registerAPIKeyRoutes(group *gin.RouterGroup, authRepo auth.Repository) {
+	route := group.Group("/api-keys")
+	// Keys are managed by signed-in users only, so that a key, whatever its scopes,
+	// cannot mint further keys.
+	route.Use(auth.RequireUserToken(), auth.RequirePermission(auth.NewPermission("api-keys", auth.ActionManage)))
+
+	route.POST("", func(c *gin.Context) {
+		var req struct {
+			Name      string     `json:"name" binding:"required,max=255"`
+			OwnerID   string     `json:"owner_id"`
+			Scopes    []string   `json:"scopes" binding:"required,min=1"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		actor, _ := auth.CurrentUser(c)
+		if req.OwnerID == "" {
+			req.OwnerID = actor.ID
+		}
+
+		owner, err := authRepo.FindUserByID(c.Request.Context(), req.OwnerID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "owner not found"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+
+		key, err := auth.NewAPIKey(req.Name, owner.ID, req.Scopes, req.ExpiresAt)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := authRepo.CreateAPIKey(c.Request.Context(), key); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		recordSecurityEvent(c, authRepo, auth.EventAPIKeyCreated, owner, "", "key "+key.Prefix+" created by "+actor.ID)
+		c.JSON(http.StatusCreated, key)
+	})
+
+	route.GET("", func(c *gin.Context) {
+		keys, err := authRepo.ListAPIKeys(c.Request.Context())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, keys)
+	})
+
+	route.DELETE(":id", func(c *gin.Context) {
+		if err := authRepo.RevokeAPIKey(c.Request.Context(), c.Param("id"), time.Now()); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		actor, _ := auth.CurrentUser(c)
+		recordSecurityEvent(c, authRepo, auth.EventAPIKeyRevoked, nil, "", "key "+c.Param("id")+" revoked by "+actor.ID)
+		c.Status(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,448 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"merch-app-codex/internal/auth"
+	"merch-app-codex/internal/config"
+	"merch-app-codex/internal/mail"
+	"merch-app-codex/internal/storage/mysql"
+)
+
+func registerAuthRoutes(api *gin.RouterGroup, cfg config.Config, authRepo auth.Repository, authenticator *auth.ChainAuthenticator, sessionCfg auth.SessionConfig, guard *auth.LoginGuard, policy *auth.TwoFactorPolicy, passwordPolicy *auth.PasswordPolicy, mailer mail.Mailer) {
+	authGroup := api.Group("/auth")
+	authGroup.POST("/login", func(c *gin.Context) {
+		var req struct {
+			Email       string `json:"email" binding:"required"`
+			Password    string `json:"password" binding:"required"`
+			DeviceLabel string `json:"device_label" binding:"max=255"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ip := c.ClientIP()
+		wait, err := guard.Wait(c.Request.Context(), req.Email, ip, time.Now())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if wait > 0 {
+			recordSecurityEvent(c, authRepo, auth.EventLoginThrottled, nil, req.Email, "")
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many login attempts, try again later"})
+			return
+		}
+
+		user, backend, err := authenticator.Authenticate(c.Request.Context(), req.Email, req.Password)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				rejectLogin(c, authRepo, guard, nil, req.Email, "invalid credentials")
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !user.Active {
+			rejectDeactivated(c, authRepo, user, req.Email)
+			return
+		}
+		upgradePasswordHash(c, authRepo, user, req.Password)
+
+		// The guard keeps counting until the second factor is verified as well.
+		if user.TwoFactorEnabled() {
+			challenge, err := auth.NewTwoFactorChallenge(user.ID, req.DeviceLabel, cfg.TwoFactorTTL, time.Now())
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if err := authRepo.CreateTwoFactorChallenge(c.Request.Context(), challenge); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"two_factor_required": true,
+				"challenge":           challenge.Token,
+				"expires_at":          challenge.ExpiresAt,
+			})
+			return
+		}
+
+		if err := guard.Succeed(c.Request.Context(), req.Email, ip); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordSecurityEvent(c, authRepo, auth.EventLoginSucceeded, user, req.Email, backend)
+
+		token, err := createSession(c, authRepo, sessionCfg, user, req.DeviceLabel)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, tokenResponse(token))
+	})
+
+	authGroup.POST("/refresh", func(c *gin.Context) {
+		var req struct {
+			RefreshToken string `json:"refresh_token" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		previous, user, err := authRepo.FindUserTokenByRefresh(c.Request.Context(), req.RefreshToken)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !user.Active {
+			rejectDeactivated(c, authRepo, user, "")
+			return
+		}
+
+		token, err := auth.NewUserToken(user.ID, sessionCfg, time.Now())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		token.DeviceLabel = previous.DeviceLabel
+		token.IP, token.UserAgent = auth.ClientInfo(c)
+
+		if err := authRepo.RotateUserToken(c.Request.Context(), previous.ID, token); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := sessionCfg.IssueAccessToken(c.Request.Context(), authRepo, user, token, time.Now()); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, tokenResponse(token))
+	})
+
+	authGroup.POST("/password/forgot", func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// The response never reveals whether the email belongs to an account.
+		user, err := authRepo.FindUserByEmail(c.Request.Context(), req.Email)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			recordSecurityEvent(c, authRepo, auth.EventPasswordResetRequested, nil, req.Email, "unknown email")
+			c.Status(http.StatusAccepted)
+			return
+		}
+		if !user.Active {
+			recordSecurityEvent(c, authRepo, auth.EventPasswordResetRequested, user, req.Email, "account deactivated")
+			c.Status(http.StatusAccepted)
+			return
+		}
+
+		tokenValue, err := auth.GenerateToken()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		resetToken := &mysql.PasswordResetToken{
+			UserID:    user.ID,
+			Token:     tokenValue,
+			ExpiresAt: time.Now().Add(cfg.PasswordResetTTL),
+		}
+		resetToken.SetID(mysql.NewID())
+
+		if err := authRepo.CreatePasswordResetToken(c.Request.Context(), resetToken); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		link := strings.TrimRight(cfg.AppURL, "/") + "/reset-password?token=" + url.QueryEscape(tokenValue)
+		if err := mailer.Send(c.Request.Context(), passwordResetMessage(user, link, cfg.PasswordResetTTL)); err != nil {
+			log.Printf("failed to send password reset email to %s: %v", user.Email, err)
+		}
+
+		recordSecurityEvent(c, authRepo, auth.EventPasswordResetRequested, user, req.Email, "")
+		c.Status(http.StatusAccepted)
+	})
+
+	authGroup.POST("/password/reset", func(c *gin.Context) {
+		var req struct {
+			Token    string `json:"token" binding:"required"`
+			Password string `json:"password" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		_, user, err := authRepo.FindPasswordResetToken(c.Request.Context(), req.Token, time.Now())
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := passwordPolicy.Validate(req.Password, user.Email, user.Name); err != nil {
+			abortWithValidationError(c, err)
+			return
+		}
+
+		user, err = authRepo.ResetPassword(c.Request.Context(), req.Token, req.Password, time.Now())
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := guard.Unlock(c.Request.Context(), auth.ThrottleEmail, user.Email); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		recordSecurityEvent(c, authRepo, auth.EventPasswordResetCompleted, user, user.Email, "")
+		c.Status(http.StatusNoContent)
+	})
+
+	registerMeRoutes(authGroup, authRepo, sessionCfg, passwordPolicy)
+	registerTwoFactorRoutes(authGroup, cfg, authRepo, sessionCfg, guard, policy)
+	registerInvitationAcceptRoutes(authGroup, authRepo, passwordPolicy)
+
+	session := authGroup.Group("")
+	session.Use(auth.TokenAuthMiddleware(authRepo, sessionCfg), auth.LoadSession(authRepo), auth.RequireUserToken())
+
+	session.POST("/logout", func(c *gin.Context) {
+		token, ok := auth.CurrentToken(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		if err := authRepo.DeleteUserTokenByID(c.Request.Context(), token.ID); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if _, ok := auth.CurrentActor(c); ok {
+			user, _ := auth.CurrentUser(c)
+			recordSecurityEvent(c, authRepo, auth.EventImpersonationEnded, user, "", "")
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	session.POST("/logout-all", auth.ForbidImpersonation(), func(c *gin.Context) {
+		user, ok := auth.CurrentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+			return
+		}
+
+		if err := authRepo.DeleteUserTokens(c.Request.Context(), user.ID); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	session.GET("/sessions", func(c *gin.Context) {
+		user, _ := auth.CurrentUser(c)
+		current, _ := auth.CurrentToken(c)
+
+		userID := user.ID
+		if requested := c.Query("user_id"); requested != "" && requested != user.ID {
+			if !auth.Allowed(user.Role, auth.NewPermission("sessions", auth.ActionManage)) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+				return
+			}
+			userID = requested
+		}
+
+		tokens, err := authRepo.ListUserTokens(c.Request.Context(), userID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		sessions := make([]sessionResponse, 0, len(tokens))
+		for _, token := range tokens {
+			sessions = append(sessions, sessionResponse{
+				UserToken: token,
+				Current:   current != nil && token.ID == current.ID,
+			})
+		}
+		c.JSON(http.StatusOK, sessions)
+	})
+
+	session.DELETE("/sessions/:id", func(c *gin.Context) {
+		user, _ := auth.CurrentUser(c)
+
+		token, err := authRepo.FindUserTokenByID(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if token.UserID != user.ID && !auth.Allowed(user.Role, auth.NewPermission("sessions", auth.ActionManage)) {
+			// Sessions of other users are reported as missing rather than forbidden.
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": gorm.ErrRecordNotFound.Error()})
+			return
+		}
+
+		if err := authRepo.DeleteUserTokenByID(c.Request.Context(), token.ID); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// sessionResponse describes a login session without exposing token values.
+type sessionResponse struct {
+	mysql.UserToken
+	Current bool `json:"current"`
+}
+
+func tokenResponse(token *mysql.UserToken) gin.H {
+	return gin.H{
+		"token":              token.Token,
+		"refresh_token":      token.RefreshToken,
+		"expires_at":         token.ExpiresAt,
+		"refresh_expires_at": token.RefreshExpiresAt,
+	}
+}
+
+// createSession issues and stores a new token pair for the user on the requesting client.
+func createSession(c *gin.Context, authRepo auth.Repository, sessionCfg auth.SessionConfig, user *mysql.User, deviceLabel string) (*mysql.UserToken, error) {
+	token, err := auth.NewUserToken(user.ID, sessionCfg, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	token.DeviceLabel = deviceLabel
+	token.IP, token.UserAgent = auth.ClientInfo(c)
+
+	if err := authRepo.CreateUserToken(c.Request.Context(), token); err != nil {
+		return nil, err
+	}
+	if err := sessionCfg.IssueAccessToken(c.Request.Context(), authRepo, user, token, time.Now()); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// upgradePasswordHash rehashes a correct password that is still stored with
+// bcrypt or outdated parameters. Failures are logged because the login itself
+// has already succeeded.
+func upgradePasswordHash(c *gin.Context, authRepo auth.Repository, user *mysql.User, password string) {
+	if !user.PasswordNeedsRehash() {
+		return
+	}
+	user.Password = password
+	if err := authRepo.UpdateUserColumns(c.Request.Context(), user, "password"); err != nil {
+		log.Printf("failed to upgrade password hash of user %s: %v", user.ID, err)
+	}
+}
+
+// rejectLogin registers a failed login attempt and answers with a generic error.
+func rejectLogin(c *gin.Context, authRepo auth.Repository, guard *auth.LoginGuard, user *mysql.User, email, detail string) {
+	if err := guard.Fail(c.Request.Context(), email, c.ClientIP(), time.Now()); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordSecurityEvent(c, authRepo, auth.EventLoginFailed, user, email, detail)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+}
+
+// rejectDeactivated refuses to sign in a deactivated user. It is only called once
+// the credentials have been verified, so it reveals nothing to a guessing client.
+func rejectDeactivated(c *gin.Context, authRepo auth.Repository, user *mysql.User, email string) {
+	recordSecurityEvent(c, authRepo, auth.EventLoginFailed, user, email, "account deactivated")
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "account is deactivated"})
+}
+
+// recordSecurityEvent stores a security event describing the current request.
+// Events of impersonated requests also name the administrator behind them.
+// Failures are logged rather than returned so that auditing never blocks a response.
+func recordSecurityEvent(c *gin.Context, authRepo auth.Repository, eventType string, user *mysql.User, email, detail string) {
+	ip, userAgent := auth.ClientInfo(c)
+	event := &mysql.SecurityEvent{
+		Type:      eventType,
+		Email:     auth.NormalizeEmail(email),
+		IP:        ip,
+		UserAgent: userAgent,
+		Detail:    detail,
+	}
+	if user != nil {
+		event.UserID = &user.ID
+		if event.Email == "" {
+			event.Email = user.Email
+		}
+	}
+	if actor, ok := auth.CurrentActor(c); ok {
+		event.ActorID = &actor.ID
+	}
+
+	if err := authRepo.CreateSecurityEvent(c.Request.Context(), event); err != nil {
+		log.Printf("failed to record security event %s: %v", eventType, err)
+	}
+}
+
+func passwordResetMessage(user *mysql.User, link string, ttl time.Duration) mail.Message {
+	return mail.Message{
+		To:      user.Email,
+		Subject: "Восстановление пароля",
+		Body: "Здравствуйте, " + user.Name + "!\n\n" +
+			"Чтобы задать новый пароль, перейдите по ссылке:\n" + link + "\n\n" +
+			"Ссылка действительна " + formatTTL(ttl) + " и может быть использована один раз. " +
+			"Если вы не запрашивали восстановление пароля, просто проигнорируйте это письмо.\n",
+	}
+}
+
+// formatTTL renders a lifetime in whole hours or minutes for human readers.
+func formatTTL(ttl time.Duration) string {
+	if ttl >= time.Hour && ttl%time.Hour == 0 {
+		return strconv.Itoa(int(ttl.Hours())) + " ч."
+	}
+	return strconv.Itoa(int(ttl.Minutes())) + " мин."
+}
@@ -1,19 +1,96 @@
 package server
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"merch-app-codex/internal/auth"
 	"merch-app-codex/internal/storage/mysql"
 )
 
+// entityPermissions declares the permission required for each verb of an entity route.
+type entityPermissions struct {
+	read   auth.Permission
+	create auth.Permission
+	update auth.Permission
+	delete auth.Permission
+}
+
+// crudPermissions maps each verb to the matching action on the resource.
+func crudPermissions(resource string) entityPermissions {
+	return entityPermissions{
+		read:   auth.NewPermission(resource, auth.ActionRead),
+		create: auth.NewPermission(resource, auth.ActionCreate),
+		update: auth.NewPermission(resource, auth.ActionUpdate),
+		delete: auth.NewPermission(resource, auth.ActionDelete),
+	}
+}
+
 type entityFactory[Model any, Ptr interface {
 	*Model
 	mysql.Entity
 }] struct {
-	path string
-	new  func() Ptr
+	path        string
+	permissions entityPermissions
+	new         func() Ptr
+	// validate optionally applies request-level rules, such as the password
+	// policy, that the model cannot check on its own.
+	validate func(Ptr) error
+	// remove optionally replaces deletion for entities, such as users, that must
+	// outlive the rows referencing them. It receives the version from If-Match,
+	// if any, and must make its write conditional on it.
+	remove func(c *gin.Context, version *time.Time)
+	// filters and sorts whitelist the query parameters and the sort columns of
+	// the list route; lists are always sortable by ID.
+	filters map[string]listFilter
+	sorts   []string
+}
+
+// abortWithStorageError maps repository errors onto HTTP responses.
+func abortWithStorageError(c *gin.Context, err error) {
+	if errors.Is(err, mysql.ErrOutOfScope) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, mysql.ErrVersionMismatch) {
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// abortWithValidationError answers 400, listing password policy violations separately.
+func abortWithValidationError(c *gin.Context, err error) {
+	var policyErr *auth.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error(), "violations": policyErr.Violations})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// validateEntity runs model-level validation for entities implementing mysql.Validator
+// followed by the factory's own validation.
+func validateEntity[Model any, Ptr interface {
+	*Model
+	mysql.Entity
+}](c *gin.Context, factory entityFactory[Model, Ptr], entity Ptr) bool {
+	if validator, ok := any(entity).(mysql.Validator); ok {
+		if err := validator.Validate(); err != nil {
+			abortWithValidationError(c, err)
+			return false
+		}
+	}
+	if factory.validate != nil {
+		if err := factory.validate(entity); err != nil {
+			abortWithValidationError(c, err)
+			return false
+		}
+	}
+	return true
 }
 
 func registerEntityRoutes[Model any, Ptr interface {
@@ -21,8 +98,9 @@ func registerEntityRoutes[Model any, Ptr interface {
 	mysql.Entity
 }](group *gin.RouterGroup, repo *mysql.Repository, factory entityFactory[Model, Ptr]) {
 	route := group.Group(factory.path)
+	_, searchable := any(factory.new()).(mysql.Searchable)
 
-	route.POST("", func(c *gin.Context) {
+	route.POST("", auth.RequirePermission(factory.permissions.create), func(c *gin.Context) {
 		entity := factory.new()
 		if err := c.ShouldBindJSON(entity); err != nil {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -33,39 +111,62 @@ func registerEntityRoutes[Model any, Ptr interface {
 			entity.SetID(mysql.NewID())
 		}
 
+		if !validateEntity(c, factory, entity) {
+			return
+		}
+
 		if err := repo.Create(c.Request.Context(), entity); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			abortWithStorageError(c, err)
 			return
 		}
 
+		setEntityTag(c, entity)
 		c.JSON(http.StatusCreated, entity)
 	})
 
-	route.GET("", func(c *gin.Context) {
-		var list []Model
-		if err := repo.List(c.Request.Context(), &list); err != nil {
+	route.GET("", auth.RequirePermission(factory.permissions.read), func(c *gin.Context) {
+		pageReq, err := parseListRequest(c, factory.filters, factory.sorts, searchable)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		list := []Model{}
+		page, err := repo.ListPage(c.Request.Context(), &list, pageReq)
+		if err != nil {
+			if errors.Is(err, mysql.ErrCursorNotFound) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+
+		setPageHeaders(c, page)
 		c.JSON(http.StatusOK, list)
 	})
 
-	route.GET(":id", func(c *gin.Context) {
+	route.GET(":id", auth.RequirePermission(factory.permissions.read), func(c *gin.Context) {
 		entity := factory.new()
 		if err := repo.FindByID(c.Request.Context(), entity, c.Param("id")); err != nil {
 			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
+		setEntityTag(c, entity)
 		c.JSON(http.StatusOK, entity)
 	})
 
-	route.PUT(":id", func(c *gin.Context) {
+	route.PUT(":id", auth.RequirePermission(factory.permissions.update), func(c *gin.Context) {
 		id := c.Param("id")
 		entity := factory.new()
 		if err := repo.FindByID(c.Request.Context(), entity, id); err != nil {
 			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
+		version, ok := checkIfMatch(c, entity)
+		if !ok {
+			return
+		}
 
 		if err := c.ShouldBindJSON(entity); err != nil {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -73,16 +174,81 @@ func registerEntityRoutes[Model any, Ptr interface {
 		}
 
 		entity.SetID(id)
-		if err := repo.Update(c.Request.Context(), entity); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if !validateEntity(c, factory, entity) {
+			return
+		}
+
+		if err := repo.Update(c.Request.Context(), entity, version); err != nil {
+			abortWithStorageError(c, err)
 			return
 		}
+		setEntityTag(c, entity)
 		c.JSON(http.StatusOK, entity)
 	})
 
-	route.DELETE(":id", func(c *gin.Context) {
-		if err := repo.DeleteByID(c.Request.Context(), factory.new(), c.Param("id")); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	route.PATCH(":id", auth.RequirePermission(factory.permissions.update), func(c *gin.Context) {
+		if contentType := c.ContentType(); contentType != mergePatchContentType && contentType != "application/json" {
+			c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": "content type must be " + mergePatchContentType})
+			return
+		}
+
+		id := c.Param("id")
+		entity := factory.new()
+		if err := repo.FindByID(c.Request.Context(), entity, id); err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		version, ok := checkIfMatch(c, entity)
+		if !ok {
+			return
+		}
+		original := *entity
+
+		patch, err := c.GetRawData()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := applyMergePatch(entity, patch); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		entity.SetID(id)
+		if !validateEntity(c, factory, entity) {
+			return
+		}
+
+		if err := repo.Patch(c.Request.Context(), entity, &original, version); err != nil {
+			abortWithStorageError(c, err)
+			return
+		}
+		setEntityTag(c, entity)
+		c.JSON(http.StatusOK, entity)
+	})
+
+	route.DELETE(":id", auth.RequirePermission(factory.permissions.delete), func(c *gin.Context) {
+		id := c.Param("id")
+		var version *time.Time
+		if c.GetHeader("If-Match") != "" {
+			entity := factory.new()
+			if err := repo.FindByID(c.Request.Context(), entity, id); err != nil {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			var ok bool
+			if version, ok = checkIfMatch(c, entity); !ok {
+				return
+			}
+		}
+
+		if factory.remove != nil {
+			factory.remove(c, version)
+			return
+		}
+
+		if err := repo.DeleteByID(c.Request.Context(), factory.new(), id, version); err != nil {
+			abortWithStorageError(c, err)
 			return
 		}
 		c.Status(http.StatusNoContent)
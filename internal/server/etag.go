@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// entityTag formats a row version as a strong entity tag.
+func entityTag(version time.Time) string {
+	return strconv.Quote(strconv.FormatInt(version.UnixMicro(), 10))
+}
+
+// setEntityTag reports the version of a versioned entity in the ETag header.
+func setEntityTag(c *gin.Context, entity any) {
+	if versioned, ok := entity.(mysql.Versioned); ok {
+		c.Header("ETag", entityTag(versioned.Version()))
+	}
+}
+
+// checkIfMatch evaluates the If-Match header (RFC 9110) against the loaded
+// entity. It answers 412 and returns false when no listed tag matches. Otherwise
+// it returns the version the write must be conditional on, which is nil without
+// the header so that such writes stay unconditional.
+func checkIfMatch(c *gin.Context, entity any) (*time.Time, bool) {
+	header := c.GetHeader("If-Match")
+	versioned, ok := entity.(mysql.Versioned)
+	if header == "" || !ok {
+		return nil, true
+	}
+
+	version := versioned.Version()
+	current := entityTag(version)
+	for _, tag := range strings.Split(header, ",") {
+		// Weak tags never match under the strong comparison If-Match requires.
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == current {
+			return &version, true
+		}
+	}
+
+	c.Header("ETag", current)
+	c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "record has been modified since it was read"})
+	return nil, false
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"merch-app-codex/internal/auth"
+	"merch-app-codex/internal/config"
+)
+
+// impersonationDetailLimit matches the size of the security_events.detail column.
+const impersonationDetailLimit = 255
+
+func registerImpersonationRoutes(group *gin.RouterGroup, cfg config.Config, authRepo auth.Repository, sessionCfg auth.SessionConfig) {
+	group.POST("/users/:id/impersonate",
+		auth.RequirePermission(auth.NewPermission("users", auth.ActionManage)),
+		auth.RequireUserToken(),
+		auth.ForbidImpersonation(),
+		func(c *gin.Context) {
+			actor, _ := auth.CurrentUser(c)
+
+			target, err := authRepo.FindUserByID(c.Request.Context(), c.Param("id"))
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := auth.CheckImpersonation(actor, target); err != nil {
+				status := http.StatusBadRequest
+				if errors.Is(err, auth.ErrImpersonateAdmin) {
+					status = http.StatusForbidden
+				}
+				c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+				return
+			}
+
+			now := time.Now()
+			token, err := auth.NewImpersonationToken(target.ID, actor.ID, cfg.ImpersonationTTL, now)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			token.IP, token.UserAgent = auth.ClientInfo(c)
+
+			if err := authRepo.CreateUserToken(c.Request.Context(), token); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if err := sessionCfg.IssueAccessToken(c.Request.Context(), authRepo, target, token, now); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			recordSecurityEvent(c, authRepo, auth.EventImpersonationStarted, target, "", "by "+actor.ID)
+
+			response := tokenResponse(token)
+			response["email"] = target.Email
+			c.JSON(http.StatusOK, response)
+		})
+}
+
+// auditImpersonation records every request made under impersonation once it has
+// been handled, naming both the impersonated user and the administrator. It runs
+// ahead of authentication so that it covers every route group.
+func auditImpersonation(authRepo auth.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if _, ok := auth.CurrentActor(c); !ok {
+			return
+		}
+		user, _ := auth.CurrentUser(c)
+
+		detail := c.Request.Method + " " + c.Request.URL.EscapedPath() + " " + strconv.Itoa(c.Writer.Status())
+		if len(detail) > impersonationDetailLimit {
+			detail = detail[:impersonationDetailLimit]
+		}
+		recordSecurityEvent(c, authRepo, auth.EventImpersonatedRequest, user, "", detail)
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"merch-app-codex/internal/auth"
+	"merch-app-codex/internal/config"
+	"merch-app-codex/internal/mail"
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// invitationResponse describes an invitation together with its current status.
+type invitationResponse struct {
+	mysql.UserInvitation
+	Status string `json:"status"`
+}
+
+func registerInvitationRoutes(group *gin.RouterGroup, cfg config.Config, authRepo auth.Repository, mailer mail.Mailer) {
+	route := group.Group("/invitations")
+
+	route.POST("", auth.RequirePermission(auth.NewPermission("invitations", auth.ActionCreate)), func(c *gin.Context) {
+		var req struct {
+			Email string     `json:"email" binding:"required,email,max=255"`
+			Name  string     `json:"name" binding:"required,max=255"`
+			Role  mysql.Role `json:"role"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.Email = strings.TrimSpace(req.Email)
+
+		user, err := authRepo.FindUserByEmail(c.Request.Context(), req.Email)
+		isNew := errors.Is(err, gorm.ErrRecordNotFound)
+		switch {
+		case isNew:
+			user = &mysql.User{Name: req.Name, Email: req.Email, Role: req.Role}
+			user.SetID(mysql.NewID())
+			if err := user.Validate(); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		case user.HasPassword():
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a user with this email already exists"})
+			return
+		}
+
+		tokenValue, err := auth.GenerateToken()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		actor, _ := auth.CurrentUser(c)
+		invitation := &mysql.UserInvitation{
+			Email:     user.Email,
+			Token:     tokenValue,
+			InvitedBy: &actor.ID,
+			ExpiresAt: time.Now().Add(cfg.InvitationTTL),
+		}
+		invitation.SetID(mysql.NewID())
+
+		if err := authRepo.CreateInvitation(c.Request.Context(), user, isNew, invitation); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		link := strings.TrimRight(cfg.AppURL, "/") + "/accept-invitation?token=" + url.QueryEscape(tokenValue)
+		if err := mailer.Send(c.Request.Context(), invitationMessage(user, link, cfg.InvitationTTL)); err != nil {
+			log.Printf("failed to send invitation email to %s: %v", user.Email, err)
+		}
+
+		recordSecurityEvent(c, authRepo, auth.EventInvitationSent, user, "", "invited by "+actor.ID)
+		c.JSON(http.StatusCreated, invitationResponse{UserInvitation: *invitation, Status: mysql.InvitationPending})
+	})
+
+	// Invitations reveal pending sign-up addresses, so the "*:read" grant of
+	// supervisors and read-only users does not cover them.
+	route.GET("", auth.RequirePermission(auth.NewPermission("invitations", auth.ActionManage)), func(c *gin.Context) {
+		filter := mysql.InvitationFilter{
+			Status: c.Query("status"),
+			Email:  strings.TrimSpace(c.Query("email")),
+			Now:    time.Now(),
+		}
+		switch filter.Status {
+		case "", mysql.InvitationPending, mysql.InvitationAccepted, mysql.InvitationExpired, mysql.InvitationRevoked:
+		default:
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "status must be one of pending, accepted, expired, revoked"})
+			return
+		}
+
+		invitations, err := authRepo.ListInvitations(c.Request.Context(), filter)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		response := make([]invitationResponse, 0, len(invitations))
+		for _, invitation := range invitations {
+			response = append(response, invitationResponse{UserInvitation: invitation, Status: invitation.Status(filter.Now)})
+		}
+		c.JSON(http.StatusOK, response)
+	})
+
+	route.DELETE("/:id", auth.RequirePermission(auth.NewPermission("invitations", auth.ActionDelete)), func(c *gin.Context) {
+		if err := authRepo.RevokeInvitation(c.Request.Context(), c.Param("id"), time.Now()); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "pending invitation not found"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		actor, _ := auth.CurrentUser(c)
+		recordSecurityEvent(c, authRepo, auth.EventInvitationRevoked, nil, "", "invitation "+c.Param("id")+" revoked by "+actor.ID)
+		c.Status(http.StatusNoContent)
+	})
+}
+
+func registerInvitationAcceptRoutes(authGroup *gin.RouterGroup, authRepo auth.Repository, passwordPolicy *auth.PasswordPolicy) {
+	route := authGroup.Group("/invitations")
+
+	route.GET("/lookup", func(c *gin.Context) {
+		_, user, err := authRepo.FindInvitation(c.Request.Context(), c.Query("token"), time.Now())
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "invalid or expired invitation"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"email": user.Email, "name": user.Name})
+	})
+
+	route.POST("/accept", func(c *gin.Context) {
+		var req struct {
+			Token    string `json:"token" binding:"required"`
+			Password string `json:"password" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		_, invited, err := authRepo.FindInvitation(c.Request.Context(), req.Token, time.Now())
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid or expired invitation"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := passwordPolicy.Validate(req.Password, invited.Email, invited.Name); err != nil {
+			abortWithValidationError(c, err)
+			return
+		}
+
+		user, err := authRepo.AcceptInvitation(c.Request.Context(), req.Token, req.Password, time.Now())
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid or expired invitation"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		recordSecurityEvent(c, authRepo, auth.EventInvitationAccepted, user, "", "")
+		c.Status(http.StatusNoContent)
+	})
+}
+
+func invitationMessage(user *mysql.User, link string, ttl time.Duration) mail.Message {
+	return mail.Message{
+		To:      user.Email,
+		Subject: "Приглашение в Merch App",
+		Body: "Здравствуйте, " + user.Name + "!\n\n" +
+			"Вас пригласили в Merch App. Чтобы задать пароль и войти, перейдите по ссылке:\n" + link + "\n\n" +
+			"Ссылка действительна " + formatTTL(ttl) + " и может быть использована один раз.\n",
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// maxFilterValues bounds the number of values of an IN filter.
+const maxFilterValues = 100
+
+// pageParams are the query parameters shared by every list route.
+var pageParams = []string{"limit", "after", "before", "total", "sort"}
+
+// filterKind selects how the values of a filter parameter are parsed.
+type filterKind int
+
+const (
+	filterID filterKind = iota
+	filterString
+	filterTime
+	filterNumber
+	filterBool
+)
+
+// listFilter declares a query parameter accepted by a list route and the
+// condition it applies. FilterNull parameters take a boolean whatever their kind.
+type listFilter struct {
+	column string
+	op     mysql.FilterOp
+	kind   filterKind
+}
+
+// parseListRequest reads the page parameters together with the filters and the
+// sort order whitelisted for the entity, and the q search of searchable entities.
+// Parameters outside of the whitelist are rejected rather than ignored so that
+// typos do not silently widen a list.
+func parseListRequest(c *gin.Context, filters map[string]listFilter, sorts []string, searchable bool) (mysql.PageRequest, error) {
+	req, err := parsePageRequest(c)
+	if err != nil {
+		return req, err
+	}
+
+	query := c.Request.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if slices.Contains(pageParams, name) {
+			continue
+		}
+		if name == "q" && searchable {
+			if req.Search, err = mysql.ParseSearch(query.Get(name)); err != nil {
+				return req, fmt.Errorf("q: %w", err)
+			}
+			continue
+		}
+		spec, ok := filters[name]
+		if !ok {
+			return req, fmt.Errorf("unknown query parameter %q", name)
+		}
+		filter, err := spec.parse(query.Get(name))
+		if err != nil {
+			return req, fmt.Errorf("%s: %w", name, err)
+		}
+		req.Filters = append(req.Filters, filter)
+	}
+
+	if value := c.Query("sort"); value != "" {
+		column := strings.TrimPrefix(value, "-")
+		if column != "id" && !slices.Contains(sorts, column) {
+			return req, fmt.Errorf("cannot sort by %q", column)
+		}
+		if column != "id" {
+			req.Sort.Column = column
+		}
+		req.Sort.Desc = strings.HasPrefix(value, "-")
+	}
+	return req, nil
+}
+
+func (f listFilter) parse(raw string) (mysql.Filter, error) {
+	filter := mysql.Filter{Column: f.column, Op: f.op}
+
+	switch f.op {
+	case mysql.FilterNull:
+		isNull, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter, errors.New("must be a boolean")
+		}
+		filter.Value = isNull
+		return filter, nil
+	case mysql.FilterIn:
+		parts := strings.Split(raw, ",")
+		if len(parts) > maxFilterValues {
+			return filter, fmt.Errorf("at most %d values are allowed", maxFilterValues)
+		}
+		values := make([]interface{}, 0, len(parts))
+		for _, part := range parts {
+			value, err := f.value(strings.TrimSpace(part))
+			if err != nil {
+				return filter, err
+			}
+			values = append(values, value)
+		}
+		filter.Value = values
+		return filter, nil
+	}
+
+	value, err := f.value(raw)
+	if err != nil {
+		return filter, err
+	}
+	filter.Value = value
+
+	// An upper bound given as a date includes the whole day.
+	if day, ok := value.(time.Time); ok && f.op == mysql.FilterLTE && len(raw) == len(time.DateOnly) {
+		filter.Op = mysql.FilterLT
+		filter.Value = day.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+func (f listFilter) value(raw string) (interface{}, error) {
+	switch f.kind {
+	case filterID:
+		id := strings.ToUpper(raw)
+		if !mysql.ValidID(id) {
+			return nil, fmt.Errorf("%q is not an ID", raw)
+		}
+		return id, nil
+	case filterTime:
+		if value, err := time.Parse(time.RFC3339, raw); err == nil {
+			return value, nil
+		}
+		value, err := time.ParseInLocation(time.DateOnly, raw, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a date or an RFC 3339 time", raw)
+		}
+		return value, nil
+	case filterNumber:
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", raw)
+		}
+		return value, nil
+	case filterBool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", raw)
+		}
+		return value, nil
+	default:
+		return raw, nil
+	}
+}
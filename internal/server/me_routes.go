@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"merch-app-codex/internal/auth"
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// profileResponse describes the authenticated user together with their effective access.
+type profileResponse struct {
+	*mysql.User
+	Roles          []mysql.Role      `json:"roles"`
+	Permissions    []auth.Permission `json:"permissions"`
+	Scopes         []string          `json:"scopes,omitempty"`
+	TwoFactor      bool              `json:"two_factor_enabled"`
+	ImpersonatorID string            `json:"impersonator_id,omitempty"`
+}
+
+func newProfileResponse(c *gin.Context, user *mysql.User) profileResponse {
+	response := profileResponse{
+		User:        user,
+		Roles:       []mysql.Role{user.Role},
+		Permissions: auth.PermissionsFor(user.Role),
+		TwoFactor:   user.TwoFactorEnabled(),
+	}
+	if key, ok := auth.CurrentAPIKey(c); ok {
+		response.Scopes = key.Scopes
+	}
+	if actor, ok := auth.CurrentActor(c); ok {
+		response.ImpersonatorID = actor.ID
+	}
+	return response
+}
+
+func registerMeRoutes(authGroup *gin.RouterGroup, authRepo auth.Repository, sessionCfg auth.SessionConfig, passwordPolicy *auth.PasswordPolicy) {
+	me := authGroup.Group("/me")
+	me.Use(auth.TokenAuthMiddleware(authRepo, sessionCfg), auth.LoadSession(authRepo))
+
+	me.GET("", func(c *gin.Context) {
+		user, _ := auth.CurrentUser(c)
+		c.JSON(http.StatusOK, newProfileResponse(c, user))
+	})
+
+	me.PUT("", auth.RequireUserToken(), auth.ForbidImpersonation(), func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name" binding:"required,max=255"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, _ := auth.CurrentUser(c)
+		user.Name = req.Name
+		if err := authRepo.UpdateUserColumns(c.Request.Context(), user, "name"); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, newProfileResponse(c, user))
+	})
+
+	me.POST("/password", auth.RequireUserToken(), auth.ForbidImpersonation(), func(c *gin.Context) {
+		var req struct {
+			CurrentPassword string `json:"current_password" binding:"required"`
+			NewPassword     string `json:"new_password" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, _ := auth.CurrentUser(c)
+		if err := user.CheckPassword(req.CurrentPassword); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "current password is incorrect"})
+			return
+		}
+
+		if err := passwordPolicy.Validate(req.NewPassword, user.Email, user.Name); err != nil {
+			abortWithValidationError(c, err)
+			return
+		}
+
+		user.Password = req.NewPassword
+		if err := authRepo.UpdateUserColumns(c.Request.Context(), user, "password"); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Other sessions may belong to whoever learned the old password.
+		token, _ := auth.CurrentToken(c)
+		if err := authRepo.DeleteOtherUserTokens(c.Request.Context(), user.ID, token.ID); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		recordSecurityEvent(c, authRepo, auth.EventPasswordChanged, user, "", "")
+		c.Status(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// mergePatchContentType is the media type of RFC 7396 JSON Merge Patch documents.
+const mergePatchContentType = "application/merge-patch+json"
+
+var jsonNull = []byte("null")
+
+// readOnlyMembers name the fields a merge patch cannot change; they are ignored.
+var readOnlyMembers = []string{"id", "created_at", "updated_at"}
+
+// applyMergePatch applies an RFC 7396 merge patch to the entity: members set to
+// null clear the field, absent members leave it untouched and any other value
+// replaces it. Clearing a field that cannot hold null is an error rather than a
+// silent no-op.
+func applyMergePatch(entity any, patch []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &members); err != nil || members == nil {
+		return errors.New("merge patch must be a JSON object")
+	}
+	for _, name := range readOnlyMembers {
+		delete(members, name)
+	}
+	writable, err := json.Marshal(members)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(writable, entity); err != nil {
+		return err
+	}
+
+	var cleared []string
+	for name, value := range members {
+		if bytes.Equal(bytes.TrimSpace(value), jsonNull) {
+			cleared = append(cleared, name)
+		}
+	}
+	if len(cleared) == 0 {
+		return nil
+	}
+
+	encoded, err := json.Marshal(entity)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return err
+	}
+	sort.Strings(cleared)
+	for _, name := range cleared {
+		if value, ok := fields[name]; ok && !bytes.Equal(value, jsonNull) {
+			return fmt.Errorf("%s cannot be null", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+func TestApplyMergePatch(t *testing.T) {
+	parent := "01HZY3S0MS1G5B2TQ4V6W8X0YZ"
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	stored := func() *mysql.Category {
+		category := &mysql.Category{Name: "Drinks", ParentID: &parent}
+		category.SetID("01HZY3S0MS1G5B2TQ4V6W8X0Z0")
+		category.CreatedAt, category.UpdatedAt = createdAt, createdAt
+		return category
+	}
+
+	tests := []struct {
+		name    string
+		patch   string
+		check   func(*mysql.Category) bool
+		wantErr string
+	}{
+		{
+			name:  "absent members are kept",
+			patch: `{"name": "Juices"}`,
+			check: func(c *mysql.Category) bool {
+				return c.Name == "Juices" && c.ParentID != nil && *c.ParentID == parent
+			},
+		},
+		{
+			name:  "null clears a nullable field",
+			patch: `{"parent_id": null}`,
+			check: func(c *mysql.Category) bool { return c.ParentID == nil && c.Name == "Drinks" },
+		},
+		{
+			name:    "null on a required field is rejected",
+			patch:   `{"name": null, "parent_id": null}`,
+			wantErr: "name cannot be null",
+		},
+		{
+			name:  "read-only members are ignored",
+			patch: `{"id": "01HZY3S0MS1G5B2TQ4V6W8X0Z1", "created_at": "2020-01-01T00:00:00Z", "updated_at": null, "name": "Juices"}`,
+			check: func(c *mysql.Category) bool {
+				return c.ID == "01HZY3S0MS1G5B2TQ4V6W8X0Z0" && c.CreatedAt.Equal(createdAt) && c.UpdatedAt.Equal(createdAt) && c.Name == "Juices"
+			},
+		},
+		{
+			name:    "not an object",
+			patch:   `["name"]`,
+			wantErr: "JSON object",
+		},
+		{
+			name:    "null document",
+			patch:   `null`,
+			wantErr: "JSON object",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			category := stored()
+			err := applyMergePatch(category, []byte(tt.patch))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("applyMergePatch error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyMergePatch: %v", err)
+			}
+			if !tt.check(category) {
+				t.Fatalf("patched category %+v", category)
+			}
+		})
+	}
+}
+
+func TestApplyMergePatchNullByFieldType(t *testing.T) {
+	item := &mysql.VisitItem{}
+	price := 12.5
+	item.Price = &price
+	if err := applyMergePatch(item, []byte(`{"price": null}`)); err != nil || item.Price != nil {
+		t.Fatalf("price not cleared: %v, %v", err, item.Price)
+	}
+
+	visit := &mysql.Visit{VisitedAt: time.Now(), Notes: "shelf restocked"}
+	if err := applyMergePatch(visit, []byte(`{"visited_at": null}`)); err == nil || !strings.Contains(err.Error(), "visited_at") {
+		t.Fatalf("applyMergePatch error = %v, want visited_at cannot be null", err)
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"merch-app-codex/internal/auth"
+	"merch-app-codex/internal/config"
+	"merch-app-codex/internal/oidc"
+	"merch-app-codex/internal/storage/mysql"
+)
+
+const (
+	oidcCookieName = "oidc_login"
+	oidcCookiePath = "/api/auth/oidc"
+	// oidcCookieMaxAge bounds how long the user may spend at the identity provider.
+	oidcCookieMaxAge = 10 * 60
+)
+
+func registerOIDCRoutes(authGroup *gin.RouterGroup, cfg config.Config, authRepo auth.Repository, sessionCfg auth.SessionConfig, client *oidc.Client) {
+	route := authGroup.Group("/oidc")
+	secure := strings.HasPrefix(cfg.AppURL, "https://")
+
+	route.GET("/login", func(c *gin.Context) {
+		state, err := oidc.RandomString()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		nonce, err := oidc.RandomString()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		verifier, err := oidc.RandomString()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		target, err := client.AuthCodeURL(c.Request.Context(), state, nonce, oidc.CodeChallenge(verifier))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+
+		redirect := safeRedirect(c.Query("redirect"))
+		value := strings.Join([]string{state, nonce, verifier, base64.RawURLEncoding.EncodeToString([]byte(redirect))}, ".")
+
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(oidcCookieName, value, oidcCookieMaxAge, oidcCookiePath, "", secure, true)
+		c.Redirect(http.StatusFound, target)
+	})
+
+	route.GET("/callback", func(c *gin.Context) {
+		finish := func(fragment url.Values) {
+			c.SetSameSite(http.SameSiteLaxMode)
+			c.SetCookie(oidcCookieName, "", -1, oidcCookiePath, "", secure, true)
+			c.Redirect(http.StatusFound, strings.TrimRight(cfg.AppURL, "/")+"/login/oidc#"+fragment.Encode())
+		}
+		fail := func(user *mysql.User, email, detail string) {
+			recordSecurityEvent(c, authRepo, auth.EventLoginFailed, user, email, "oidc: "+detail)
+			finish(url.Values{"error": {"single sign-on failed"}})
+		}
+
+		if providerError := c.Query("error"); providerError != "" {
+			fail(nil, "", "provider returned "+providerError)
+			return
+		}
+
+		cookie, err := c.Cookie(oidcCookieName)
+		if err != nil {
+			fail(nil, "", "login session expired")
+			return
+		}
+		parts := strings.Split(cookie, ".")
+		if len(parts) != 4 || parts[0] == "" || parts[0] != c.Query("state") {
+			fail(nil, "", "state mismatch")
+			return
+		}
+		nonce, verifier := parts[1], parts[2]
+		redirect, _ := base64.RawURLEncoding.DecodeString(parts[3])
+
+		tokens, err := client.Exchange(c.Request.Context(), c.Query("code"), verifier)
+		if err != nil {
+			fail(nil, "", err.Error())
+			return
+		}
+
+		claims, err := client.VerifyIDToken(c.Request.Context(), tokens.IDToken, nonce)
+		if err != nil {
+			fail(nil, "", err.Error())
+			return
+		}
+
+		user, err := resolveOIDCUser(c, cfg, authRepo, claims)
+		if err != nil {
+			fail(nil, claims.Email, err.Error())
+			return
+		}
+		if !user.Active {
+			fail(user, claims.Email, "account deactivated")
+			return
+		}
+
+		fragment, err := oidcLoginFragment(c, cfg, authRepo, sessionCfg, user)
+		if err != nil {
+			fail(user, claims.Email, err.Error())
+			return
+		}
+		if !user.TwoFactorEnabled() {
+			recordSecurityEvent(c, authRepo, auth.EventLoginSucceeded, user, claims.Email, "oidc")
+		}
+
+		fragment.Set("redirect", safeRedirect(string(redirect)))
+		finish(fragment)
+	})
+}
+
+// oidcLoginFragment completes a single sign-on of the resolved user. Users with
+// two-factor authentication receive a challenge to redeem at /login/verify
+// instead of tokens, as after a password login.
+func oidcLoginFragment(c *gin.Context, cfg config.Config, authRepo auth.Repository, sessionCfg auth.SessionConfig, user *mysql.User) (url.Values, error) {
+	if user.TwoFactorEnabled() {
+		challenge, err := auth.NewTwoFactorChallenge(user.ID, "", cfg.TwoFactorTTL, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if err := authRepo.CreateTwoFactorChallenge(c.Request.Context(), challenge); err != nil {
+			return nil, err
+		}
+		return url.Values{
+			"two_factor_required": {"true"},
+			"challenge":           {challenge.Token},
+			"email":               {user.Email},
+		}, nil
+	}
+
+	token, err := createSession(c, authRepo, sessionCfg, user, "")
+	if err != nil {
+		return nil, err
+	}
+	return url.Values{
+		"token":         {token.Token},
+		"refresh_token": {token.RefreshToken},
+		"email":         {user.Email},
+	}, nil
+}
+
+// resolveOIDCUser maps provider claims onto a local user: first by linked subject,
+// then by verified email (linking the subject), and finally by provisioning a new
+// user when just-in-time provisioning is enabled.
+func resolveOIDCUser(c *gin.Context, cfg config.Config, authRepo auth.Repository, claims *oidc.Claims) (*mysql.User, error) {
+	ctx := c.Request.Context()
+
+	user, err := authRepo.FindUserByOIDCSubject(ctx, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errors.New("identity provider did not supply a verified email")
+	}
+
+	subject := claims.Subject
+	user, err = authRepo.FindUserByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		if user.OIDCSubject != nil && *user.OIDCSubject != subject {
+			return nil, errors.New("account is linked to another identity")
+		}
+		user.OIDCSubject = &subject
+		if err := authRepo.UpdateUserColumns(ctx, user, "oidc_subject"); err != nil {
+			return nil, err
+		}
+		return user, nil
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	case !cfg.OIDCAutoProvision:
+		return nil, errors.New("no account exists for " + claims.Email)
+	}
+
+	// Provisioned users sign in through the identity provider; the random
+	// password only satisfies the NOT NULL column and is never disclosed.
+	password, err := auth.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.Email
+	}
+
+	user = &mysql.User{
+		Name:        name,
+		Email:       claims.Email,
+		Password:    password,
+		Role:        mysql.Role(cfg.OIDCDefaultRole),
+		OIDCSubject: &subject,
+	}
+	user.SetID(mysql.NewID())
+	if err := user.Validate(); err != nil {
+		return nil, fmt.Errorf("provisioning: %w", err)
+	}
+
+	if err := authRepo.CreateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// safeRedirect only allows same-origin paths as post-login destinations.
+func safeRedirect(value string) string {
+	if !strings.HasPrefix(value, "/") || strings.HasPrefix(value, "//") || strings.HasPrefix(value, "/\\") {
+		return "/"
+	}
+	return value
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"merch-app-codex/internal/auth"
+	"merch-app-codex/internal/config"
+	"merch-app-codex/internal/oidc"
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// userStore is an in-memory auth.Repository covering the user lookups and writes
+// of resolveOIDCUser and the sessions and challenges of oidcLoginFragment. Other
+// methods panic through the nil embedded interface.
+type userStore struct {
+	auth.Repository
+	users      []*mysql.User
+	updates    [][]string
+	tokens     []*mysql.UserToken
+	challenges []*mysql.TwoFactorChallenge
+}
+
+func (s *userStore) find(match func(*mysql.User) bool) (*mysql.User, error) {
+	for _, user := range s.users {
+		if match(user) {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *userStore) FindUserByEmail(ctx context.Context, email string) (*mysql.User, error) {
+	return s.find(func(u *mysql.User) bool { return u.Email == email })
+}
+
+func (s *userStore) FindUserByOIDCSubject(ctx context.Context, subject string) (*mysql.User, error) {
+	return s.find(func(u *mysql.User) bool { return u.OIDCSubject != nil && *u.OIDCSubject == subject })
+}
+
+func (s *userStore) CreateUser(ctx context.Context, user *mysql.User) error {
+	copied := *user
+	s.users = append(s.users, &copied)
+	return nil
+}
+
+func (s *userStore) UpdateUserColumns(ctx context.Context, user *mysql.User, columns ...string) error {
+	s.updates = append(s.updates, columns)
+	for i, stored := range s.users {
+		if stored.ID == user.ID {
+			copied := *user
+			s.users[i] = &copied
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (s *userStore) CreateUserToken(ctx context.Context, token *mysql.UserToken) error {
+	s.tokens = append(s.tokens, token)
+	return nil
+}
+
+func (s *userStore) CreateTwoFactorChallenge(ctx context.Context, challenge *mysql.TwoFactorChallenge) error {
+	s.challenges = append(s.challenges, challenge)
+	return nil
+}
+
+func newStoredUser(id, email string, subject *string) *mysql.User {
+	user := &mysql.User{Name: email, Email: email, Role: mysql.RoleSupervisor, Active: true, OIDCSubject: subject}
+	user.SetID(id)
+	return user
+}
+
+func oidcTestContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/auth/oidc/callback", nil)
+	return c
+}
+
+func TestResolveOIDCUser(t *testing.T) {
+	linked := "linked-subject"
+	other := "other-subject"
+
+	tests := []struct {
+		name          string
+		autoProvision bool
+		users         []*mysql.User
+		claims        oidc.Claims
+		wantID        string
+		wantErr       string
+		wantUpdates   int
+	}{
+		{
+			name:   "matches linked subject",
+			users:  []*mysql.User{newStoredUser("u1", "ivan@example.com", &linked)},
+			claims: oidc.Claims{Subject: linked, Email: "renamed@example.com"},
+			wantID: "u1",
+		},
+		{
+			name:        "links by verified email",
+			users:       []*mysql.User{newStoredUser("u1", "ivan@example.com", nil)},
+			claims:      oidc.Claims{Subject: "new-subject", Email: "ivan@example.com", EmailVerified: true},
+			wantID:      "u1",
+			wantUpdates: 1,
+		},
+		{
+			name:    "refuses unverified email",
+			users:   []*mysql.User{newStoredUser("u1", "ivan@example.com", nil)},
+			claims:  oidc.Claims{Subject: "new-subject", Email: "ivan@example.com"},
+			wantErr: "verified email",
+		},
+		{
+			name:    "refuses account linked to another subject",
+			users:   []*mysql.User{newStoredUser("u1", "ivan@example.com", &other)},
+			claims:  oidc.Claims{Subject: "new-subject", Email: "ivan@example.com", EmailVerified: true},
+			wantErr: "linked to another identity",
+		},
+		{
+			name:    "refuses unknown email without provisioning",
+			claims:  oidc.Claims{Subject: "new-subject", Email: "ivan@example.com", EmailVerified: true},
+			wantErr: "no account exists",
+		},
+		{
+			name:          "provisions unknown email",
+			autoProvision: true,
+			claims:        oidc.Claims{Subject: "new-subject", Email: "ivan@example.com", EmailVerified: true, Name: "Ivan Petrov"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &userStore{users: tt.users}
+			cfg := config.Config{OIDCAutoProvision: tt.autoProvision, OIDCDefaultRole: string(mysql.RoleMerchandiser)}
+
+			user, err := resolveOIDCUser(oidcTestContext(), cfg, store, &tt.claims)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveOIDCUser error = %v, want %q", err, tt.wantErr)
+				}
+				if len(store.updates) != 0 {
+					t.Fatalf("rejected sign-in updated users: %v", store.updates)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveOIDCUser: %v", err)
+			}
+
+			if tt.wantID != "" && user.ID != tt.wantID {
+				t.Fatalf("resolved user %q, want %q", user.ID, tt.wantID)
+			}
+			if len(store.updates) != tt.wantUpdates {
+				t.Fatalf("user updated %d times, want %d", len(store.updates), tt.wantUpdates)
+			}
+			if tt.wantUpdates > 0 && strings.Join(store.updates[0], ",") != "oidc_subject" {
+				t.Fatalf("updated columns %v, want only oidc_subject", store.updates[0])
+			}
+
+			stored, err := store.FindUserByOIDCSubject(context.Background(), tt.claims.Subject)
+			if err != nil {
+				t.Fatalf("subject %q is not linked to a stored user", tt.claims.Subject)
+			}
+			if stored.ID != user.ID {
+				t.Fatalf("subject linked to %q, want %q", stored.ID, user.ID)
+			}
+		})
+	}
+}
+
+func TestResolveOIDCUserProvisionsWithDefaultRole(t *testing.T) {
+	store := &userStore{}
+	cfg := config.Config{OIDCAutoProvision: true, OIDCDefaultRole: string(mysql.RoleReadOnly)}
+	claims := &oidc.Claims{Subject: "new-subject", Email: "ivan@example.com", EmailVerified: true}
+
+	user, err := resolveOIDCUser(oidcTestContext(), cfg, store, claims)
+	if err != nil {
+		t.Fatalf("resolveOIDCUser: %v", err)
+	}
+	if len(store.users) != 1 {
+		t.Fatalf("stored %d users, want 1", len(store.users))
+	}
+	created := store.users[0]
+	switch {
+	case !mysql.ValidID(created.ID) || created.ID != user.ID:
+		t.Fatalf("provisioned user ID %q", created.ID)
+	case created.Role != mysql.RoleReadOnly:
+		t.Fatalf("provisioned role %q, want %q", created.Role, mysql.RoleReadOnly)
+	case created.Name != claims.Email:
+		t.Fatalf("provisioned name %q, want the email when the provider sends none", created.Name)
+	case created.Password == "":
+		t.Fatal("provisioned user has no password to satisfy the column")
+	}
+
+	cfg.OIDCDefaultRole = "owner"
+	claims.Subject, claims.Email = "another-subject", "anna@example.com"
+	if _, err := resolveOIDCUser(oidcTestContext(), cfg, store, claims); err == nil || !strings.Contains(err.Error(), "provisioning") {
+		t.Fatalf("resolveOIDCUser error = %v, want provisioning failure for an unknown role", err)
+	}
+}
+
+func TestOIDCLoginFragmentRequiresSecondFactor(t *testing.T) {
+	cfg := config.Config{TwoFactorTTL: 5 * time.Minute}
+	sessionCfg := auth.SessionConfig{AccessTTL: time.Hour, RefreshTTL: 24 * time.Hour}
+
+	secret := "JBSWY3DPEHPK3PXP"
+	enabledAt := time.Now()
+	enrolled := newStoredUser("u1", "ivan@example.com", nil)
+	enrolled.TOTPSecret, enrolled.TOTPEnabledAt = &secret, &enabledAt
+
+	store := &userStore{users: []*mysql.User{enrolled}}
+	fragment, err := oidcLoginFragment(oidcTestContext(), cfg, store, sessionCfg, enrolled)
+	if err != nil {
+		t.Fatalf("oidcLoginFragment: %v", err)
+	}
+	if fragment.Has("token") || fragment.Has("refresh_token") || len(store.tokens) != 0 {
+		t.Fatalf("enrolled user got a session before the second factor: %v", fragment)
+	}
+	if len(store.challenges) != 1 {
+		t.Fatalf("created %d challenges, want 1", len(store.challenges))
+	}
+	challenge := store.challenges[0]
+	if fragment.Get("challenge") != challenge.Token || challenge.UserID != enrolled.ID || fragment.Get("two_factor_required") != "true" {
+		t.Fatalf("fragment %v does not hand over the challenge of %q", fragment, enrolled.ID)
+	}
+
+	plain := newStoredUser("u2", "anna@example.com", nil)
+	store = &userStore{users: []*mysql.User{plain}}
+	fragment, err = oidcLoginFragment(oidcTestContext(), cfg, store, sessionCfg, plain)
+	if err != nil {
+		t.Fatalf("oidcLoginFragment: %v", err)
+	}
+	if len(store.challenges) != 0 || fragment.Has("challenge") {
+		t.Fatalf("user without two-factor authentication got a challenge: %v", fragment)
+	}
+	if len(store.tokens) != 1 || fragment.Get("token") != store.tokens[0].Token || fragment.Get("refresh_token") == "" {
+		t.Fatalf("fragment %v does not carry the created session", fragment)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// Page sizes of list endpoints. Larger limits are silently capped.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// parsePageRequest reads the limit, after, before and total query parameters.
+func parsePageRequest(c *gin.Context) (mysql.PageRequest, error) {
+	req := mysql.PageRequest{Limit: defaultPageSize}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return req, errors.New("limit must be a positive integer")
+		}
+		req.Limit = min(limit, maxPageSize)
+	}
+
+	req.After = strings.ToUpper(c.Query("after"))
+	if req.After != "" && !mysql.ValidID(req.After) {
+		return req, errors.New("after must be an ID")
+	}
+	req.Before = strings.ToUpper(c.Query("before"))
+	if req.Before != "" && !mysql.ValidID(req.Before) {
+		return req, errors.New("before must be an ID")
+	}
+
+	if value := c.Query("total"); value != "" {
+		total, err := strconv.ParseBool(value)
+		if err != nil {
+			return req, errors.New("total must be a boolean")
+		}
+		req.CountTotal = total
+	}
+	return req, nil
+}
+
+// setPageHeaders links the adjacent pages in an RFC 8288 Link header and reports
+// the total count, when it was requested, in X-Total-Count. The response body
+// stays a plain array so that existing clients keep working.
+func setPageHeaders(c *gin.Context, page mysql.Page) {
+	var links []string
+	if page.Next != "" {
+		links = append(links, pageLink(c, "after", page.Next, "next"))
+	}
+	if page.Prev != "" {
+		links = append(links, pageLink(c, "before", page.Prev, "prev"))
+	}
+	if len(links) > 0 {
+		c.Header("Link", strings.Join(links, ", "))
+	}
+	if page.Total != nil {
+		c.Header("X-Total-Count", strconv.FormatInt(*page.Total, 10))
+	}
+}
+
+// pageLink builds the URL of an adjacent page, keeping the other query parameters.
+func pageLink(c *gin.Context, param, cursor, rel string) string {
+	query := c.Request.URL.Query()
+	query.Del("after")
+	query.Del("before")
+	query.Set(param, cursor)
+	return fmt.Sprintf("<%s?%s>; rel=%q", c.Request.URL.Path, query.Encode(), rel)
+}
@@ -1,7 +1,6 @@
 package server
 
 import (
-	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -9,136 +8,194 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 
 	"merch-app-codex/internal/auth"
 	"merch-app-codex/internal/config"
+	"merch-app-codex/internal/mail"
+	"merch-app-codex/internal/oidc"
 	"merch-app-codex/internal/report"
 	"merch-app-codex/internal/storage/mysql"
 )
 
 // NewRouter wires all HTTP handlers and middleware.
-func NewRouter(cfg config.Config, repo *mysql.Repository, authRepo auth.Repository, reportService *report.Service) *gin.Engine {
+func NewRouter(cfg config.Config, repo *mysql.Repository, authRepo auth.Repository, reportService *report.Service, mailer mail.Mailer, passwordPolicy *auth.PasswordPolicy, signer *auth.TokenSigner, authenticator *auth.ChainAuthenticator) *gin.Engine {
 	router := gin.Default()
 
 	api := router.Group("/api")
+	api.Use(auditImpersonation(authRepo))
+
+	sessionCfg := auth.SessionConfig{
+		AccessTTL:  cfg.AccessTokenTTL,
+		RefreshTTL: cfg.RefreshTokenTTL,
+	}
+	if signer != nil {
+		// Signed tokens cannot be extended on use, so they are kept short-lived
+		// and renewed through the refresh token.
+		sessionCfg.AccessTTL = cfg.SignedTokenTTL
+		sessionCfg.Signer = signer
+		sessionCfg.Denylist = auth.NewDenylist(authRepo, cfg.DenylistRefresh)
+		authRepo.OnSessionsRevoked(sessionCfg.Denylist.SessionsRevoked)
+	}
+
+	guard := auth.NewLoginGuard(authRepo, auth.LockoutConfig{
+		MaxFailures:   cfg.LoginMaxFailures,
+		IPMaxFailures: cfg.LoginIPMaxFailures,
+		Lockout:       cfg.LoginLockout,
+		DelayBase:     cfg.LoginDelayBase,
+		DelayMax:      cfg.LoginDelayMax,
+	})
 
-	authGroup := api.Group("/auth")
-	authGroup.POST("/login", func(c *gin.Context) {
-		var req struct {
-			Email    string `json:"email" binding:"required"`
-			Password string `json:"password" binding:"required"`
-		}
-
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		user, err := authRepo.FindUserByEmail(c.Request.Context(), req.Email)
-		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
-				return
-			}
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		if err := user.CheckPassword(req.Password); err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
-			return
-		}
+	policy := auth.NewTwoFactorPolicy(authRepo)
 
-		tokenValue, err := auth.GenerateToken()
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+	registerAuthRoutes(api, cfg, authRepo, authenticator, sessionCfg, guard, policy, passwordPolicy, mailer)
 
-		token := &mysql.UserToken{
-			UserID: user.ID,
-			Token:  tokenValue,
-		}
-		token.SetID(mysql.NewID())
-
-		if err := authRepo.CreateUserToken(c.Request.Context(), token); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"token": token.Token})
-	})
+	if cfg.OIDCIssuerURL != "" {
+		oidcClient := oidc.NewClient(oidc.Config{
+			IssuerURL:    cfg.OIDCIssuerURL,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+		}, nil)
+		registerOIDCRoutes(api.Group("/auth"), cfg, authRepo, sessionCfg, oidcClient)
+	}
 
 	secured := api.Group("")
-	secured.Use(auth.TokenAuthMiddleware(authRepo))
+	secured.Use(
+		auth.TokenAuthMiddleware(authRepo, sessionCfg),
+		auth.RequireTwoFactorEnrollment(policy),
+		auth.ScopeMiddleware(authRepo),
+	)
 
 	registerEntityRoutes[mysql.User, *mysql.User](secured, repo, entityFactory[mysql.User, *mysql.User]{
-		path: "/users",
-		new:  func() *mysql.User { return &mysql.User{} },
+		path:        "/users",
+		permissions: crudPermissions("users"),
+		new:         func() *mysql.User { return &mysql.User{} },
+		validate: func(user *mysql.User) error {
+			if user.Password == "" {
+				return nil
+			}
+			return passwordPolicy.Validate(user.Password, user.Email, user.Name)
+		},
+		// Users are deactivated rather than deleted so that their visits stay attributable.
+		remove: func(c *gin.Context, version *time.Time) {
+			setUserActive(c, authRepo, false, version)
+		},
+		filters: map[string]listFilter{
+			"role":   {column: "role", op: mysql.FilterIn, kind: filterString},
+			"active": {column: "active", op: mysql.FilterEq, kind: filterBool},
+		},
+		sorts: []string{"name", "email"},
 	})
 
 	registerEntityRoutes[mysql.Company, *mysql.Company](secured, repo, entityFactory[mysql.Company, *mysql.Company]{
-		path: "/companies",
-		new:  func() *mysql.Company { return &mysql.Company{} },
+		path:        "/companies",
+		permissions: crudPermissions("companies"),
+		new:         func() *mysql.Company { return &mysql.Company{} },
+		sorts:       []string{"name"},
 	})
 
 	registerEntityRoutes[mysql.RetailPoint, *mysql.RetailPoint](secured, repo, entityFactory[mysql.RetailPoint, *mysql.RetailPoint]{
-		path: "/retail-points",
-		new:  func() *mysql.RetailPoint { return &mysql.RetailPoint{} },
+		path:        "/retail-points",
+		permissions: crudPermissions("retail-points"),
+		new:         func() *mysql.RetailPoint { return &mysql.RetailPoint{} },
+		filters: map[string]listFilter{
+			"company_id": {column: "company_id", op: mysql.FilterIn, kind: filterID},
+		},
+		sorts: []string{"name"},
 	})
 
 	registerEntityRoutes[mysql.Brand, *mysql.Brand](secured, repo, entityFactory[mysql.Brand, *mysql.Brand]{
-		path: "/brands",
-		new:  func() *mysql.Brand { return &mysql.Brand{} },
+		path:        "/brands",
+		permissions: crudPermissions("brands"),
+		new:         func() *mysql.Brand { return &mysql.Brand{} },
+		sorts:       []string{"name"},
 	})
 
 	registerEntityRoutes[mysql.Category, *mysql.Category](secured, repo, entityFactory[mysql.Category, *mysql.Category]{
-		path: "/categories",
-		new:  func() *mysql.Category { return &mysql.Category{} },
+		path:        "/categories",
+		permissions: crudPermissions("categories"),
+		new:         func() *mysql.Category { return &mysql.Category{} },
+		filters: map[string]listFilter{
+			"parent_id":      {column: "parent_id", op: mysql.FilterIn, kind: filterID},
+			"parent_id_null": {column: "parent_id", op: mysql.FilterNull},
+		},
+		sorts: []string{"name"},
 	})
 
 	registerEntityRoutes[mysql.Product, *mysql.Product](secured, repo, entityFactory[mysql.Product, *mysql.Product]{
-		path: "/products",
-		new:  func() *mysql.Product { return &mysql.Product{} },
+		path:        "/products",
+		permissions: crudPermissions("products"),
+		new:         func() *mysql.Product { return &mysql.Product{} },
+		filters: map[string]listFilter{
+			"brand_id":    {column: "brand_id", op: mysql.FilterIn, kind: filterID},
+			"category_id": {column: "category_id", op: mysql.FilterIn, kind: filterID},
+			"sku":         {column: "sku", op: mysql.FilterEq, kind: filterString},
+			"sku_null":    {column: "sku", op: mysql.FilterNull},
+		},
+		sorts: []string{"name"},
 	})
 
 	registerEntityRoutes[mysql.Visit, *mysql.Visit](secured, repo, entityFactory[mysql.Visit, *mysql.Visit]{
-		path: "/visits",
+		path:        "/visits",
+		permissions: crudPermissions("visits"),
 		new: func() *mysql.Visit {
 			return &mysql.Visit{VisitedAt: time.Now()}
 		},
+		filters: map[string]listFilter{
+			"user_id":         {column: "user_id", op: mysql.FilterIn, kind: filterID},
+			"retail_point_id": {column: "retail_point_id", op: mysql.FilterIn, kind: filterID},
+			"visited_from":    {column: "visited_at", op: mysql.FilterGTE, kind: filterTime},
+			"visited_to":      {column: "visited_at", op: mysql.FilterLTE, kind: filterTime},
+		},
+		sorts: []string{"visited_at"},
 	})
 
 	registerEntityRoutes[mysql.VisitItem, *mysql.VisitItem](secured, repo, entityFactory[mysql.VisitItem, *mysql.VisitItem]{
-		path: "/visit-items",
-		new:  func() *mysql.VisitItem { return &mysql.VisitItem{} },
+		path:        "/visit-items",
+		permissions: crudPermissions("visit-items"),
+		new:         func() *mysql.VisitItem { return &mysql.VisitItem{} },
+		filters: map[string]listFilter{
+			"visit_id":   {column: "visit_id", op: mysql.FilterIn, kind: filterID},
+			"product_id": {column: "product_id", op: mysql.FilterIn, kind: filterID},
+			"price_from": {column: "price", op: mysql.FilterGTE, kind: filterNumber},
+			"price_to":   {column: "price", op: mysql.FilterLTE, kind: filterNumber},
+			"price_null": {column: "price", op: mysql.FilterNull},
+		},
 	})
 
+	registerUserCompanyRoutes(secured, authRepo)
+	registerUserStatusRoutes(secured, authRepo)
+	registerImpersonationRoutes(secured, cfg, authRepo, sessionCfg)
+	registerSecurityRoutes(secured, authRepo, guard, policy)
+	registerAPIKeyRoutes(secured, authRepo)
+	registerInvitationRoutes(secured, cfg, authRepo, mailer)
+	registerSearchRoutes(secured, repo)
+
 	reports := secured.Group("/reports")
-	reports.GET("/companies/:id/visits", func(c *gin.Context) {
+	reports.GET("/companies/:id/visits", auth.RequirePermission(auth.NewPermission("reports", auth.ActionRead)), func(c *gin.Context) {
 		summary, err := reportService.CompanyVisitSummary(c.Request.Context(), c.Param("id"))
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			abortWithStorageError(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, summary)
 	})
 
 	if cfg.StaticDir != "" {
-               if info, err := os.Stat(cfg.StaticDir); err == nil && info.IsDir() {
-                       router.StaticFS("/static", gin.Dir(cfg.StaticDir, true))
-
-                       router.NoRoute(func(c *gin.Context) {
-                               if strings.HasPrefix(c.Request.URL.Path, "/api") {
-                                       c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
-                                       return
-                               }
-
-                               c.File(filepath.Join(cfg.StaticDir, "index.html"))
-                       })
-               }
-       }
+		if info, err := os.Stat(cfg.StaticDir); err == nil && info.IsDir() {
+			router.StaticFS("/static", gin.Dir(cfg.StaticDir, true))
+
+			router.NoRoute(func(c *gin.Context) {
+				if strings.HasPrefix(c.Request.URL.Path, "/api") {
+					c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+					return
+				}
+
+				c.File(filepath.Join(cfg.StaticDir, "index.html"))
+			})
+		}
+	}
 
 	return router
 }
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"merch-app-codex/internal/auth"
+	"merch-app-codex/internal/storage/mysql"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+// searchType pairs an entity covered by GET /search with the permission needed
+// to see its hits.
+type searchType struct {
+	target     mysql.SearchTarget
+	permission auth.Permission
+}
+
+var searchTypes = []searchType{
+	{mysql.SearchTarget{Type: "product", Model: &mysql.Product{}, DetailColumn: "sku"}, auth.NewPermission("products", auth.ActionRead)},
+	{mysql.SearchTarget{Type: "retail_point", Model: &mysql.RetailPoint{}, DetailColumn: "address"}, auth.NewPermission("retail-points", auth.ActionRead)},
+	{mysql.SearchTarget{Type: "company", Model: &mysql.Company{}}, auth.NewPermission("companies", auth.ActionRead)},
+	{mysql.SearchTarget{Type: "brand", Model: &mysql.Brand{}}, auth.NewPermission("brands", auth.ActionRead)},
+}
+
+func registerSearchRoutes(group *gin.RouterGroup, repo *mysql.Repository) {
+	group.GET("/search", func(c *gin.Context) {
+		query, err := mysql.ParseSearch(c.Query("q"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "q: " + err.Error()})
+			return
+		}
+
+		limit := defaultSearchLimit
+		if value := c.Query("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+				return
+			}
+			limit = min(parsed, maxSearchLimit)
+		}
+
+		var requested []string
+		if value := c.Query("type"); value != "" {
+			requested = strings.Split(value, ",")
+			for _, name := range requested {
+				if !slices.ContainsFunc(searchTypes, func(t searchType) bool { return t.target.Type == name }) {
+					c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown type " + strconv.Quote(name)})
+					return
+				}
+			}
+		}
+
+		var targets []mysql.SearchTarget
+		for _, t := range searchTypes {
+			if requested != nil && !slices.Contains(requested, t.target.Type) {
+				continue
+			}
+			if auth.Permitted(c, t.permission) {
+				targets = append(targets, t.target)
+			}
+		}
+		if len(targets) == 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+
+		groups, err := repo.Search(c.Request.Context(), query, targets, limit)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, groups)
+	})
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"merch-app-codex/internal/auth"
+	"merch-app-codex/internal/storage/mysql"
+)
+
+const (
+	defaultSecurityEventLimit = 100
+	maxSecurityEventLimit     = 1000
+)
+
+func registerSecurityRoutes(group *gin.RouterGroup, authRepo auth.Repository, guard *auth.LoginGuard, policy *auth.TwoFactorPolicy) {
+	route := group.Group("/security")
+	route.Use(auth.RequirePermission(auth.NewPermission("security", auth.ActionManage)))
+
+	route.GET("/events", func(c *gin.Context) {
+		filter := mysql.SecurityEventFilter{
+			Type:   c.Query("type"),
+			UserID: c.Query("user_id"),
+			Email:  auth.NormalizeEmail(c.Query("email")),
+			IP:     c.Query("ip"),
+			Limit:  defaultSecurityEventLimit,
+		}
+
+		if value := c.Query("since"); value != "" {
+			since, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC 3339 timestamp"})
+				return
+			}
+			filter.Since = &since
+		}
+
+		if value := c.Query("limit"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+				return
+			}
+			filter.Limit = min(limit, maxSecurityEventLimit)
+		}
+
+		events, err := authRepo.ListSecurityEvents(c.Request.Context(), filter)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, events)
+	})
+
+	route.POST("/unlock", func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email"`
+			IP    string `json:"ip"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Email == "" && req.IP == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "email or ip is required"})
+			return
+		}
+
+		actor, _ := auth.CurrentUser(c)
+		detail := "unlocked by " + actor.ID
+
+		if req.Email != "" {
+			if err := guard.Unlock(c.Request.Context(), auth.ThrottleEmail, req.Email); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			recordSecurityEvent(c, authRepo, auth.EventLoginUnlocked, nil, req.Email, detail)
+		}
+
+		if req.IP != "" {
+			if err := guard.Unlock(c.Request.Context(), auth.ThrottleIP, req.IP); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			recordSecurityEvent(c, authRepo, auth.EventLoginUnlocked, nil, "", detail+" for ip "+req.IP)
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+	route.GET("/two-factor-policy", func(c *gin.Context) {
+		roles, err := policy.RequiredRoles(c.Request.Context())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"required_roles": roles})
+	})
+
+	route.PUT("/two-factor-policy", func(c *gin.Context) {
+		var req struct {
+			RequiredRoles []mysql.Role `json:"required_roles"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, role := range req.RequiredRoles {
+			if !role.Valid() {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown role " + string(role)})
+				return
+			}
+		}
+
+		if err := policy.SetRequiredRoles(c.Request.Context(), req.RequiredRoles); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		roles, err := policy.RequiredRoles(c.Request.Context())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"required_roles": roles})
+	})
+
+	// Resetting lets a user who lost both the authenticator and the recovery codes
+	// sign in with the password alone and enroll again.
+	route.POST("/two-factor/reset", func(c *gin.Context) {
+		var req struct {
+			UserID string `json:"user_id" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := authRepo.FindUserByID(c.Request.Context(), req.UserID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		user.ResetTwoFactor()
+		if err := authRepo.UpdateUserColumns(c.Request.Context(), user, twoFactorColumns...); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		actor, _ := auth.CurrentUser(c)
+		recordSecurityEvent(c, authRepo, auth.EventTwoFactorReset, user, "", "reset by "+actor.ID)
+		c.Status(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"merch-app-codex/internal/auth"
+	"merch-app-codex/internal/config"
+	"merch-app-codex/internal/storage/mysql"
+)
+
+// twoFactorColumns are the user columns holding the TOTP enrollment.
+var twoFactorColumns = []string{"totp_secret", "totp_enabled_at", "totp_last_step", "totp_recovery_codes"}
+
+func registerTwoFactorRoutes(authGroup *gin.RouterGroup, cfg config.Config, authRepo auth.Repository, sessionCfg auth.SessionConfig, guard *auth.LoginGuard, policy *auth.TwoFactorPolicy) {
+	authGroup.POST("/login/verify", func(c *gin.Context) {
+		var req struct {
+			Challenge    string `json:"challenge" binding:"required"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Code == "" && req.RecoveryCode == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "code or recovery_code is required"})
+			return
+		}
+
+		challenge, user, err := authRepo.FindTwoFactorChallenge(c.Request.Context(), req.Challenge, time.Now())
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !user.Active {
+			rejectDeactivated(c, authRepo, user, "")
+			return
+		}
+
+		ip := c.ClientIP()
+		wait, err := guard.Wait(c.Request.Context(), user.Email, ip, time.Now())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if wait > 0 {
+			recordSecurityEvent(c, authRepo, auth.EventLoginThrottled, user, "", "two-factor")
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many login attempts, try again later"})
+			return
+		}
+
+		ok, err := verifySecondFactor(c.Request.Context(), authRepo, user, req.Code, req.RecoveryCode)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !ok {
+			if err := guard.Fail(c.Request.Context(), user.Email, ip, time.Now()); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			recordSecurityEvent(c, authRepo, auth.EventTwoFactorFailed, user, "", "login")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+			return
+		}
+
+		// Deleting the challenge guards against it being redeemed twice concurrently.
+		if err := authRepo.DeleteTwoFactorChallenge(c.Request.Context(), challenge.ID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := guard.Succeed(c.Request.Context(), user.Email, ip); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		detail := "totp"
+		if req.Code == "" {
+			detail = "recovery code"
+		}
+		recordSecurityEvent(c, authRepo, auth.EventLoginSucceeded, user, "", detail)
+
+		token, err := createSession(c, authRepo, sessionCfg, user, challenge.DeviceLabel)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, tokenResponse(token))
+	})
+
+	route := authGroup.Group("/me/2fa")
+	route.Use(auth.TokenAuthMiddleware(authRepo, sessionCfg), auth.LoadSession(authRepo), auth.RequireUserToken(), auth.ForbidImpersonation())
+
+	route.POST("/setup", func(c *gin.Context) {
+		user, _ := auth.CurrentUser(c)
+		if user.TwoFactorEnabled() {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+
+		secret, err := auth.NewTOTPSecret()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		user.TOTPSecret = &secret
+		if err := authRepo.UpdateUserColumns(c.Request.Context(), user, "totp_secret"); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":      secret,
+			"otpauth_uri": auth.TOTPURI(cfg.TOTPIssuer, user.Email, secret),
+		})
+	})
+
+	route.POST("/confirm", func(c *gin.Context) {
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, _ := auth.CurrentUser(c)
+		if user.TwoFactorEnabled() {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+		if user.TOTPSecret == nil {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "two-factor setup has not been started"})
+			return
+		}
+
+		now := time.Now()
+		step, ok := auth.VerifyTOTP(*user.TOTPSecret, req.Code, user.TOTPLastStep, now)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid two-factor code"})
+			return
+		}
+
+		codes, hashes, err := auth.NewRecoveryCodes()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		user.TOTPEnabledAt = &now
+		user.TOTPLastStep = step
+		user.TOTPRecoveryCodes = hashes
+		if err := authRepo.UpdateUserColumns(c.Request.Context(), user, twoFactorColumns...); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		recordSecurityEvent(c, authRepo, auth.EventTwoFactorEnabled, user, "", "")
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	})
+
+	route.POST("/recovery-codes", func(c *gin.Context) {
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, _ := auth.CurrentUser(c)
+		if !user.TwoFactorEnabled() {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "two-factor authentication is not enabled"})
+			return
+		}
+
+		ok, err := verifySecondFactor(c.Request.Context(), authRepo, user, req.Code, "")
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !ok {
+			recordSecurityEvent(c, authRepo, auth.EventTwoFactorFailed, user, "", "recovery codes")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid two-factor code"})
+			return
+		}
+
+		codes, hashes, err := auth.NewRecoveryCodes()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		user.TOTPRecoveryCodes = hashes
+		if err := authRepo.UpdateUserColumns(c.Request.Context(), user, "totp_recovery_codes"); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	})
+
+	route.POST("/disable", func(c *gin.Context) {
+		var req struct {
+			Password     string `json:"password" binding:"required"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, _ := auth.CurrentUser(c)
+		if !user.TwoFactorEnabled() {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "two-factor authentication is not enabled"})
+			return
+		}
+
+		required, err := policy.Required(c.Request.Context(), user.Role)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if required {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "two-factor authentication is required for your role"})
+			return
+		}
+
+		if err := user.CheckPassword(req.Password); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "current password is incorrect"})
+			return
+		}
+
+		ok, err := verifySecondFactor(c.Request.Context(), authRepo, user, req.Code, req.RecoveryCode)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !ok {
+			recordSecurityEvent(c, authRepo, auth.EventTwoFactorFailed, user, "", "disable")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid two-factor code"})
+			return
+		}
+
+		user.ResetTwoFactor()
+		if err := authRepo.UpdateUserColumns(c.Request.Context(), user, twoFactorColumns...); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		recordSecurityEvent(c, authRepo, auth.EventTwoFactorDisabled, user, "", "")
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// verifySecondFactor checks a TOTP code or, when none is given, a recovery code of
+// the user. Accepted TOTP steps and consumed recovery codes are persisted so that
+// neither can be used again.
+func verifySecondFactor(ctx context.Context, authRepo auth.Repository, user *mysql.User, code, recoveryCode string) (bool, error) {
+	if !user.TwoFactorEnabled() {
+		return false, nil
+	}
+
+	if code != "" {
+		step, ok := auth.VerifyTOTP(*user.TOTPSecret, code, user.TOTPLastStep, time.Now())
+		if !ok {
+			return false, nil
+		}
+		if err := authRepo.AdvanceTOTPStep(ctx, user.ID, step); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+		user.TOTPLastStep = step
+		return true, nil
+	}
+
+	if recoveryCode == "" {
+		return false, nil
+	}
+	previous := user.TOTPRecoveryCodes
+	if !auth.ConsumeRecoveryCode(user, recoveryCode) {
+		return false, nil
+	}
+	if err := authRepo.ConsumeRecoveryCode(ctx, user, previous); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"merch-app-codex/internal/auth"
+)
+
+func registerUserCompanyRoutes(group *gin.RouterGroup, authRepo auth.Repository) {
+	route := group.Group("/users/:id/companies")
+
+	route.GET("", auth.RequirePermission(auth.NewPermission("users", auth.ActionRead)), func(c *gin.Context) {
+		ids, err := authRepo.FindUserCompanyIDs(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"company_ids": nonNil(ids)})
+	})
+
+	route.PUT("", auth.RequirePermission(auth.NewPermission("users", auth.ActionUpdate)), func(c *gin.Context) {
+		var req struct {
+			CompanyIDs []string `json:"company_ids"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userID := c.Param("id")
+		if _, err := authRepo.FindUserByID(c.Request.Context(), userID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		ids := uniqueStrings(req.CompanyIDs)
+		if err := authRepo.SetUserCompanyIDs(c.Request.Context(), userID, ids); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"company_ids": nonNil(ids)})
+	})
+}
+
+func registerUserStatusRoutes(group *gin.RouterGroup, authRepo auth.Repository) {
+	route := group.Group("/users/:id")
+	permission := auth.RequirePermission(auth.NewPermission("users", auth.ActionUpdate))
+
+	route.POST("/deactivate", permission, func(c *gin.Context) {
+		setUserActive(c, authRepo, false, nil)
+	})
+	route.POST("/reactivate", permission, func(c *gin.Context) {
+		setUserActive(c, authRepo, true, nil)
+	})
+}
+
+// setUserActive deactivates or reactivates the user named in the path and answers
+// with the updated user. Deactivation revokes all of the user's sessions. A
+// non-nil version makes the change conditional on the user being at that version.
+func setUserActive(c *gin.Context, authRepo auth.Repository, active bool, version *time.Time) {
+	actor, _ := auth.CurrentUser(c)
+	userID := c.Param("id")
+	if !active && userID == actor.ID {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "you cannot deactivate your own account"})
+		return
+	}
+
+	user, err := authRepo.SetUserActive(c.Request.Context(), userID, active, time.Now(), version)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithStorageError(c, err)
+		return
+	}
+
+	eventType := auth.EventUserReactivated
+	if !active {
+		eventType = auth.EventUserDeactivated
+	}
+	recordSecurityEvent(c, authRepo, eventType, user, "", "by "+actor.ID)
+	setEntityTag(c, user)
+	c.JSON(http.StatusOK, user)
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if _, ok := seen[value]; ok || value == "" {
+			continue
+		}
+		seen[value] = struct{}{}
+		result = append(result, value)
+	}
+	return result
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -2,19 +2,32 @@ package mysql
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // AuthRepository handles persistence for authentication-related models.
 type AuthRepository struct {
-	db *gorm.DB
+	db        *gorm.DB
+	cache     *TokenCache
+	onRevoked func(sessions []RevokedSession)
 }
 
-// NewAuthRepository constructs an AuthRepository backed by GORM.
-func NewAuthRepository(db *gorm.DB) *AuthRepository {
-	return &AuthRepository{db: db}
+// NewAuthRepository constructs an AuthRepository backed by GORM. FindUserToken
+// results are served from cache when one is given.
+func NewAuthRepository(db *gorm.DB, cache *TokenCache) *AuthRepository {
+	return &AuthRepository{db: db, cache: cache}
+}
+
+// OnSessionsRevoked registers fn to be called with the sessions revoked by this
+// repository once their deletion is committed. It must be called before the
+// repository is used.
+func (r *AuthRepository) OnSessionsRevoked(fn func(sessions []RevokedSession)) {
+	r.onRevoked = fn
 }
 
 // FindUserByEmail retrieves a user by their email address.
@@ -26,20 +39,84 @@ func (r *AuthRepository) FindUserByEmail(ctx context.Context, email string) (*Us
 	return &user, nil
 }
 
+// FindUserByID retrieves a user by their ULID.
+func (r *AuthRepository) FindUserByID(ctx context.Context, id string) (*User, error) {
+	var user User
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindUserByOIDCSubject retrieves the user linked to an OpenID Connect subject.
+func (r *AuthRepository) FindUserByOIDCSubject(ctx context.Context, subject string) (*User, error) {
+	var user User
+	if err := r.db.WithContext(ctx).Where("oidc_subject = ?", subject).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CreateUser inserts a new user, hashing its plain-text password.
+func (r *AuthRepository) CreateUser(ctx context.Context, user *User) error {
+	return r.db.WithContext(ctx).Create(user).Error
+}
+
 // CreateUserToken stores a new authentication token for the given user.
 func (r *AuthRepository) CreateUserToken(ctx context.Context, token *UserToken) error {
 	return r.db.WithContext(ctx).Create(token).Error
 }
 
-// FindUserToken loads a user token and its associated user by token string.
+// FindUserToken loads a user token and its associated user by the plain token string.
 func (r *AuthRepository) FindUserToken(ctx context.Context, token string) (*UserToken, *User, error) {
+	hash := HashToken(token)
+	now := time.Now()
+
+	if r.cache != nil {
+		if cachedToken, cachedUser, ok := r.cache.get(hash, now); ok {
+			if !cachedToken.Expired(now) {
+				cachedToken.Token = token
+				return cachedToken, cachedUser, nil
+			}
+			r.cache.InvalidateTokens(cachedToken.ID)
+		}
+	}
+
+	var userToken UserToken
+	if err := r.db.WithContext(ctx).Where("token = ?", hash).First(&userToken).Error; err != nil {
+		return nil, nil, err
+	}
+	userToken.Token = token
+
+	if userToken.Expired(now) {
+		// Token expired; remove it eagerly unless it can still be refreshed.
+		if !userToken.Refreshable(now) {
+			_ = r.db.WithContext(ctx).Delete(&userToken).Error
+		}
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+
+	var user User
+	if err := r.db.WithContext(ctx).Where("id = ?", userToken.UserID).First(&user).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if r.cache != nil {
+		r.cache.put(hash, &userToken, &user, now)
+	}
+
+	return &userToken, &user, nil
+}
+
+// FindUserTokenByRefresh loads a user token and its associated user by refresh token string.
+func (r *AuthRepository) FindUserTokenByRefresh(ctx context.Context, refreshToken string) (*UserToken, *User, error) {
 	var userToken UserToken
-	if err := r.db.WithContext(ctx).Where("token = ?", token).First(&userToken).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("refresh_token = ?", HashToken(refreshToken)).First(&userToken).Error; err != nil {
 		return nil, nil, err
 	}
+	userToken.RefreshToken = refreshToken
 
-	if userToken.ExpiresAt != nil && userToken.ExpiresAt.Before(time.Now()) {
-		// Token expired; remove it eagerly.
+	if !userToken.Refreshable(time.Now()) {
 		_ = r.db.WithContext(ctx).Delete(&userToken).Error
 		return nil, nil, gorm.ErrRecordNotFound
 	}
@@ -52,9 +129,737 @@ func (r *AuthRepository) FindUserToken(ctx context.Context, token string) (*User
 	return &userToken, &user, nil
 }
 
-// DeleteUserToken removes a persisted token by its string value.
+// TouchUserToken records token usage, the client it came from, and moves its expiration forward.
+func (r *AuthRepository) TouchUserToken(ctx context.Context, id string, activity TokenActivity) error {
+	if err := r.db.WithContext(ctx).Set(tokenCacheManaged, true).Model(&UserToken{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"last_used_at": activity.UsedAt,
+		"expires_at":   activity.ExpiresAt,
+		"ip":           activity.IP,
+		"user_agent":   activity.UserAgent,
+	}).Error; err != nil {
+		return err
+	}
+	if r.cache != nil {
+		r.cache.touch(id, activity)
+	}
+	return nil
+}
+
+// FindUserTokenByID loads a user token by its ULID.
+func (r *AuthRepository) FindUserTokenByID(ctx context.Context, id string) (*UserToken, error) {
+	var userToken UserToken
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&userToken).Error; err != nil {
+		return nil, err
+	}
+	return &userToken, nil
+}
+
+// ListUserTokens returns the user's tokens, most recently used first.
+func (r *AuthRepository) ListUserTokens(ctx context.Context, userID string) ([]UserToken, error) {
+	var tokens []UserToken
+	if err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("last_used_at DESC, created_at DESC").
+		Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// DeleteUserTokenByID removes a persisted token by its ULID.
+func (r *AuthRepository) DeleteUserTokenByID(ctx context.Context, id string) error {
+	return r.deleteTokens(ctx, "id = ?", id)
+}
+
+// RotateUserToken replaces a previously issued token with a new one. It fails with
+// gorm.ErrRecordNotFound when the previous token has already been rotated or revoked.
+func (r *AuthRepository) RotateUserToken(ctx context.Context, previousID string, token *UserToken) error {
+	var deleted deletedTokens
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		deleted, err = deleteTokens(tx, time.Now(), "id = ?", previousID)
+		if err != nil {
+			return err
+		}
+		if len(deleted.ids) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Create(token).Error
+	})
+	if err != nil {
+		return err
+	}
+	r.tokensDeleted(deleted)
+	return nil
+}
+
+// DeleteUserToken removes a persisted token by its plain string value.
 func (r *AuthRepository) DeleteUserToken(ctx context.Context, token string) error {
-	return r.db.WithContext(ctx).Where("token = ?", token).Delete(&UserToken{}).Error
+	return r.deleteTokens(ctx, "token = ?", HashToken(token))
+}
+
+// DeleteUserTokens removes every persisted token that belongs to the given user,
+// including the impersonation sessions the user started.
+func (r *AuthRepository) DeleteUserTokens(ctx context.Context, userID string) error {
+	return r.deleteTokens(ctx, "user_id = ? OR impersonator_id = ?", userID, userID)
+}
+
+// DeleteOtherUserTokens removes every token of the user except the one with keepID.
+func (r *AuthRepository) DeleteOtherUserTokens(ctx context.Context, userID, keepID string) error {
+	return r.deleteTokens(ctx, "user_id = ? AND id <> ?", userID, keepID)
+}
+
+func (r *AuthRepository) deleteTokens(ctx context.Context, query string, args ...interface{}) error {
+	var deleted deletedTokens
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		deleted, err = deleteTokens(tx, time.Now(), query, args...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	r.tokensDeleted(deleted)
+	return nil
+}
+
+// deletedTokens lists the tokens removed by deleteTokens and the sessions it revoked.
+type deletedTokens struct {
+	ids     []string
+	revoked []RevokedSession
+}
+
+// tokensDeleted drops cached lookups of deleted tokens and reports the revoked
+// sessions once the deletion is committed.
+func (r *AuthRepository) tokensDeleted(deleted deletedTokens) {
+	if r.cache != nil && len(deleted.ids) > 0 {
+		r.cache.InvalidateTokens(deleted.ids...)
+	}
+	if r.onRevoked != nil && len(deleted.revoked) > 0 {
+		r.onRevoked(deleted.revoked)
+	}
+}
+
+// deleteTokens removes the tokens matching the query and records their sessions
+// as revoked until their access tokens would have expired, so that signed access
+// tokens issued for them are rejected as well.
+func deleteTokens(tx *gorm.DB, now time.Time, query string, args ...interface{}) (deletedTokens, error) {
+	var tokens []UserToken
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(query, args...).Find(&tokens).Error; err != nil {
+		return deletedTokens{}, err
+	}
+	if len(tokens) == 0 {
+		return deletedTokens{}, nil
+	}
+
+	ids := make([]string, 0, len(tokens))
+	revoked := make([]RevokedSession, 0, len(tokens))
+	for _, token := range tokens {
+		ids = append(ids, token.ID)
+		if token.ExpiresAt != nil && token.ExpiresAt.After(now) {
+			revoked = append(revoked, RevokedSession{SessionID: token.ID, UserID: token.UserID, ExpiresAt: *token.ExpiresAt})
+		}
+	}
+
+	if err := tx.Set(tokenCacheManaged, true).Where("id IN ?", ids).Delete(&UserToken{}).Error; err != nil {
+		return deletedTokens{}, err
+	}
+	if len(revoked) > 0 {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+			return deletedTokens{}, err
+		}
+	}
+	return deletedTokens{ids: ids, revoked: revoked}, nil
+}
+
+// ListRevokedSessions returns the revoked sessions whose access tokens have not expired yet.
+func (r *AuthRepository) ListRevokedSessions(ctx context.Context, now time.Time) ([]RevokedSession, error) {
+	var sessions []RevokedSession
+	if err := r.db.WithContext(ctx).Where("expires_at > ?", now).Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// purgeBatchSize bounds the rows deleted per statement so that purging never
+// locks a large part of a table at once.
+const purgeBatchSize = 1000
+
+// PurgeExpired deletes user tokens that can neither be used nor refreshed any
+// more, tokens unused since idleBefore unless it is zero, and expired revoked
+// sessions, two-factor challenges and password reset tokens. Tokens go through
+// deleteTokens so that idle sessions with live signed access tokens are still
+// denylisted. Rows removed before an error are counted in the result.
+func (r *AuthRepository) PurgeExpired(ctx context.Context, now, idleBefore time.Time) (PurgeResult, error) {
+	var result PurgeResult
+
+	query := "expires_at <= ? AND (refresh_token IS NULL OR refresh_expires_at <= ?)"
+	args := []interface{}{now, now}
+	if !idleBefore.IsZero() {
+		query = "(" + query + ") OR last_used_at < ? OR (last_used_at IS NULL AND created_at < ?)"
+		args = append(args, idleBefore, idleBefore)
+	}
+
+	for {
+		var ids []string
+		if err := r.db.WithContext(ctx).Model(&UserToken{}).Where(query, args...).Limit(purgeBatchSize).Pluck("id", &ids).Error; err != nil {
+			return result, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		var deleted deletedTokens
+		err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			// The condition is checked again because a token may have been used meanwhile.
+			deleted, err = deleteTokens(tx, now, "id IN ? AND ("+query+")", append([]interface{}{ids}, args...)...)
+			return err
+		})
+		if err != nil {
+			return result, err
+		}
+		r.tokensDeleted(deleted)
+		result.UserTokens += int64(len(deleted.ids))
+
+		if len(ids) < purgeBatchSize {
+			break
+		}
+	}
+
+	var err error
+	if result.RevokedSessions, err = r.purgeBatches(ctx, "revoked_sessions", now); err != nil {
+		return result, err
+	}
+	if result.TwoFactorChallenges, err = r.purgeBatches(ctx, "two_factor_challenges", now); err != nil {
+		return result, err
+	}
+	result.PasswordResetTokens, err = r.purgeBatches(ctx, "password_reset_tokens", now)
+	return result, err
+}
+
+// purgeBatches deletes the rows of table that expired by now, in batches.
+func (r *AuthRepository) purgeBatches(ctx context.Context, table string, now time.Time) (int64, error) {
+	var total int64
+	for {
+		result := r.db.WithContext(ctx).Exec("DELETE FROM "+table+" WHERE expires_at <= ? LIMIT ?", now, purgeBatchSize)
+		if result.Error != nil {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+		if result.RowsAffected < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// UpdateUserColumns writes only the named columns of the user, so that a copy
+// loaded earlier, such as one served from the token cache, cannot revert other
+// columns changed in the meantime. Naming "password" stores the hash of the
+// user's new plain-text password.
+func (r *AuthRepository) UpdateUserColumns(ctx context.Context, user *User, columns ...string) error {
+	return r.db.WithContext(ctx).Model(user).Select(columns).Updates(user).Error
+}
+
+// FindUserCompanyIDs returns the IDs of companies the user is bound to.
+func (r *AuthRepository) FindUserCompanyIDs(ctx context.Context, userID string) ([]string, error) {
+	var ids []string
+	if err := r.db.WithContext(ctx).Model(&UserCompany{}).
+		Where("user_id = ?", userID).
+		Order("company_id").
+		Pluck("company_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// SetUserCompanyIDs replaces the set of companies the user is bound to.
+func (r *AuthRepository) SetUserCompanyIDs(ctx context.Context, userID string, companyIDs []string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&UserCompany{}).Error; err != nil {
+			return err
+		}
+		if len(companyIDs) == 0 {
+			return nil
+		}
+
+		links := make([]UserCompany, 0, len(companyIDs))
+		for _, companyID := range companyIDs {
+			links = append(links, UserCompany{UserID: userID, CompanyID: companyID})
+		}
+		return tx.Create(&links).Error
+	})
+}
+
+// FindLoginThrottle loads the failure counter for the subject, returning an empty
+// counter when no failures have been recorded.
+func (r *AuthRepository) FindLoginThrottle(ctx context.Context, kind, subject string) (*LoginThrottle, error) {
+	throttle := LoginThrottle{Kind: kind, Subject: subject}
+	err := r.db.WithContext(ctx).Where("kind = ? AND subject = ?", kind, subject).First(&throttle).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+// RegisterLoginFailure increments the failure counter for the subject. Counters whose
+// last failure is older than window start again from one. When the counter reaches
+// lockAfter, the subject is locked until at+lockFor.
+func (r *AuthRepository) RegisterLoginFailure(ctx context.Context, kind, subject string, at time.Time, window time.Duration, lockAfter int, lockFor time.Duration) (*LoginThrottle, error) {
+	throttle := LoginThrottle{Kind: kind, Subject: subject}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("kind = ? AND subject = ?", kind, subject).
+			First(&throttle).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if throttle.LastFailureAt != nil && at.Sub(*throttle.LastFailureAt) > window {
+			throttle.Failures = 0
+		}
+		throttle.Failures++
+		throttle.LastFailureAt = &at
+		if lockAfter > 0 && throttle.Failures >= lockAfter {
+			lockedUntil := at.Add(lockFor)
+			throttle.LockedUntil = &lockedUntil
+			throttle.Failures = 0
+		}
+
+		return tx.Save(&throttle).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+// ResetLoginThrottle clears failures and any lock for the subject.
+func (r *AuthRepository) ResetLoginThrottle(ctx context.Context, kind, subject string) error {
+	return r.db.WithContext(ctx).Where("kind = ? AND subject = ?", kind, subject).Delete(&LoginThrottle{}).Error
+}
+
+// CreateSecurityEvent stores a security event.
+func (r *AuthRepository) CreateSecurityEvent(ctx context.Context, event *SecurityEvent) error {
+	if event.ID == "" {
+		event.SetID(NewID())
+	}
+	return r.db.WithContext(ctx).Create(event).Error
+}
+
+// ListSecurityEvents returns the most recent security events matching the filter.
+func (r *AuthRepository) ListSecurityEvents(ctx context.Context, filter SecurityEventFilter) ([]SecurityEvent, error) {
+	query := r.db.WithContext(ctx).Order("created_at DESC, id DESC")
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.UserID != "" {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.Email != "" {
+		query = query.Where("email = ?", filter.Email)
+	}
+	if filter.IP != "" {
+		query = query.Where("ip = ?", filter.IP)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var events []SecurityEvent
+	if err := query.Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// CreatePasswordResetToken stores a new password reset token.
+func (r *AuthRepository) CreatePasswordResetToken(ctx context.Context, token *PasswordResetToken) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+// SetUserActive deactivates or reactivates a user. Deactivation revokes the
+// user's sessions, including impersonation sessions the user started, pending
+// two-factor challenges and invitations; the user row itself is kept so that
+// visits and audit records stay attributable. Setting the state the user already
+// has is a no-op. A non-nil version makes the change conditional on the user row
+// being at that version; ErrVersionMismatch is returned otherwise.
+func (r *AuthRepository) SetUserActive(ctx context.Context, id string, active bool, now time.Time, version *time.Time) (*User, error) {
+	var user User
+	var deleted deletedTokens
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&user).Error; err != nil {
+			return err
+		}
+		if user.Active == active {
+			if version != nil && !user.UpdatedAt.Equal(*version) {
+				return ErrVersionMismatch
+			}
+			return nil
+		}
+
+		// The columns are read-only for GORM so that saving a user never changes them.
+		var deactivatedAt *time.Time
+		if !active {
+			deactivatedAt = &now
+		}
+		updatedAt := tx.NowFunc()
+		query := "UPDATE users SET active = ?, deactivated_at = ?, updated_at = ? WHERE id = ?"
+		args := []interface{}{active, deactivatedAt, updatedAt, id}
+		if version != nil {
+			query += " AND updated_at = ?"
+			args = append(args, *version)
+		}
+		if err := checkVersion(tx.Exec(query, args...), version); err != nil {
+			return err
+		}
+		user.Active, user.DeactivatedAt, user.UpdatedAt = active, deactivatedAt, updatedAt
+		if active {
+			return nil
+		}
+
+		if err := tx.Where("user_id = ?", id).Delete(&TwoFactorChallenge{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&UserInvitation{}).
+			Where("user_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		var err error
+		deleted, err = deleteTokens(tx, now, "user_id = ? OR impersonator_id = ?", id, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.tokensDeleted(deleted)
+	if r.cache != nil {
+		r.cache.InvalidateUser(id)
+	}
+	return &user, nil
+}
+
+// FindPasswordResetToken loads an unused, unexpired reset token and its user by
+// the plain token.
+func (r *AuthRepository) FindPasswordResetToken(ctx context.Context, token string, now time.Time) (*PasswordResetToken, *User, error) {
+	var resetToken PasswordResetToken
+	if err := r.db.WithContext(ctx).
+		Where("token = ? AND used_at IS NULL AND expires_at > ?", HashToken(token), now).
+		First(&resetToken).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var user User
+	if err := r.db.WithContext(ctx).Where("id = ?", resetToken.UserID).First(&user).Error; err != nil {
+		return nil, nil, err
+	}
+	return &resetToken, &user, nil
+}
+
+// ResetPassword consumes an unused, unexpired reset token, sets the user's new
+// password and revokes all of the user's sessions, impersonation sessions started
+// by the user and outstanding reset tokens.
+// It returns gorm.ErrRecordNotFound when the token is unknown, used or expired.
+func (r *AuthRepository) ResetPassword(ctx context.Context, token, password string, now time.Time) (*User, error) {
+	var user User
+	var deleted deletedTokens
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var resetToken PasswordResetToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token = ? AND used_at IS NULL AND expires_at > ?", HashToken(token), now).
+			First(&resetToken).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("id = ?", resetToken.UserID).First(&user).Error; err != nil {
+			return err
+		}
+
+		user.Password = password
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		var err error
+		deleted, err = deleteTokens(tx, now, "user_id = ? OR impersonator_id = ?", user.ID, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.tokensDeleted(deleted)
+	if r.cache != nil {
+		r.cache.InvalidateUser(user.ID)
+	}
+	return &user, nil
+}
+
+// CreateInvitation stores an invitation, creating the invited user first when
+// user is new. Earlier pending invitations of the same user are revoked so that
+// only the latest link works.
+func (r *AuthRepository) CreateInvitation(ctx context.Context, user *User, isNew bool, invitation *UserInvitation) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if isNew {
+			if err := tx.Create(user).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Model(&UserInvitation{}).
+			Where("user_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", user.ID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		invitation.UserID = &user.ID
+		return tx.Create(invitation).Error
+	})
+}
+
+// ListInvitations returns invitations matching the filter, newest first.
+func (r *AuthRepository) ListInvitations(ctx context.Context, filter InvitationFilter) ([]UserInvitation, error) {
+	query := r.db.WithContext(ctx).Order("created_at DESC")
+	if filter.Email != "" {
+		query = query.Where("email = ?", filter.Email)
+	}
+	switch filter.Status {
+	case InvitationAccepted:
+		query = query.Where("accepted_at IS NOT NULL")
+	case InvitationRevoked:
+		query = query.Where("accepted_at IS NULL AND revoked_at IS NOT NULL")
+	case InvitationExpired:
+		query = query.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at <= ?", filter.Now)
+	case InvitationPending:
+		query = query.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", filter.Now)
+	}
+
+	var invitations []UserInvitation
+	if err := query.Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// FindInvitation loads a pending invitation and its user by the plain token.
+func (r *AuthRepository) FindInvitation(ctx context.Context, token string, now time.Time) (*UserInvitation, *User, error) {
+	var invitation UserInvitation
+	if err := r.db.WithContext(ctx).
+		Where("token = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ? AND user_id IS NOT NULL", HashToken(token), now).
+		First(&invitation).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var user User
+	if err := r.db.WithContext(ctx).Where("id = ?", *invitation.UserID).First(&user).Error; err != nil {
+		return nil, nil, err
+	}
+	return &invitation, &user, nil
+}
+
+// AcceptInvitation consumes a pending invitation and sets the invited user's
+// password. It returns gorm.ErrRecordNotFound when the token is unknown, used,
+// revoked or expired.
+func (r *AuthRepository) AcceptInvitation(ctx context.Context, token, password string, now time.Time) (*User, error) {
+	var user User
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var invitation UserInvitation
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ? AND user_id IS NOT NULL", HashToken(token), now).
+			First(&invitation).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("id = ?", *invitation.UserID).First(&user).Error; err != nil {
+			return err
+		}
+
+		user.Password = password
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&invitation).Update("accepted_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RevokeInvitation revokes a pending invitation and deletes the invited user if
+// they never set a password or linked a single sign-on identity. It returns
+// gorm.ErrRecordNotFound when no pending invitation has the ID.
+func (r *AuthRepository) RevokeInvitation(ctx context.Context, id string, now time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var invitation UserInvitation
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id).
+			First(&invitation).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&invitation).Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+
+		if invitation.UserID == nil {
+			return nil
+		}
+		return tx.Where("id = ? AND password = '' AND oidc_subject IS NULL", *invitation.UserID).Delete(&User{}).Error
+	})
+}
+
+// CreateAPIKey stores a new API key.
+func (r *AuthRepository) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+// FindAPIKey loads an active API key and its owner by the plain key value.
+func (r *AuthRepository) FindAPIKey(ctx context.Context, key string) (*APIKey, *User, error) {
+	var apiKey APIKey
+	if err := r.db.WithContext(ctx).Where("key_hash = ?", HashToken(key)).First(&apiKey).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if !apiKey.Active(time.Now()) {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+
+	var user User
+	if err := r.db.WithContext(ctx).Where("id = ?", apiKey.OwnerID).First(&user).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return &apiKey, &user, nil
+}
+
+// ListAPIKeys returns all API keys, newest first.
+func (r *AuthRepository) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	var keys []APIKey
+	if err := r.db.WithContext(ctx).Order("created_at DESC, id DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey marks an API key as revoked. It returns gorm.ErrRecordNotFound
+// when no active key has the given ID.
+func (r *AuthRepository) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	result := r.db.WithContext(ctx).Model(&APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", at)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records the time an API key was last used.
+func (r *AuthRepository) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&APIKey{}).Where("id = ?", id).Update("last_used_at", usedAt).Error
+}
+
+// CreateTwoFactorChallenge stores a pending two-factor login challenge.
+func (r *AuthRepository) CreateTwoFactorChallenge(ctx context.Context, challenge *TwoFactorChallenge) error {
+	return r.db.WithContext(ctx).Create(challenge).Error
+}
+
+// FindTwoFactorChallenge loads an unexpired challenge and its user by the plain token.
+func (r *AuthRepository) FindTwoFactorChallenge(ctx context.Context, token string, now time.Time) (*TwoFactorChallenge, *User, error) {
+	var challenge TwoFactorChallenge
+	if err := r.db.WithContext(ctx).
+		Where("token = ? AND expires_at > ?", HashToken(token), now).
+		First(&challenge).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var user User
+	if err := r.db.WithContext(ctx).Where("id = ?", challenge.UserID).First(&user).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return &challenge, &user, nil
+}
+
+// DeleteTwoFactorChallenge consumes a challenge. It returns gorm.ErrRecordNotFound
+// when the challenge has already been used.
+func (r *AuthRepository) DeleteTwoFactorChallenge(ctx context.Context, id string) error {
+	result := r.db.WithContext(ctx).Where("id = ?", id).Delete(&TwoFactorChallenge{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// AdvanceTOTPStep records the last accepted TOTP time step of the user. It returns
+// gorm.ErrRecordNotFound when an equal or later step was already recorded, which
+// means the code is being replayed.
+func (r *AuthRepository) AdvanceTOTPStep(ctx context.Context, userID string, step int64) error {
+	// The model carries the ID so that only the user's cached tokens are dropped.
+	user := &User{}
+	user.SetID(userID)
+	result := r.db.WithContext(ctx).Model(user).
+		Where("totp_last_step < ?", step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ConsumeRecoveryCode stores the user's remaining recovery codes after one of the
+// previous codes was used. It returns gorm.ErrRecordNotFound when the stored codes
+// are no longer the previous ones, which means the code was consumed concurrently.
+func (r *AuthRepository) ConsumeRecoveryCode(ctx context.Context, user *User, previous []string) error {
+	encoded, err := json.Marshal(previous)
+	if err != nil {
+		return err
+	}
+	result := r.db.WithContext(ctx).Model(user).
+		Where("totp_recovery_codes = ?", string(encoded)).
+		Select("totp_recovery_codes").
+		Updates(user)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListRolePolicies returns the stored role policies.
+func (r *AuthRepository) ListRolePolicies(ctx context.Context) ([]RolePolicy, error) {
+	var policies []RolePolicy
+	if err := r.db.WithContext(ctx).Order("role").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// SaveRolePolicies creates or updates the given role policies.
+func (r *AuthRepository) SaveRolePolicies(ctx context.Context, policies []RolePolicy) error {
+	if len(policies) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&policies).Error
 }
 
 // DB exposes the underlying database handle for advanced queries.
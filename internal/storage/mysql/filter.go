@@ -0,0 +1,67 @@
+package mysql
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FilterOp is the comparison a Filter applies to its column.
+type FilterOp int
+
+const (
+	// FilterEq matches rows whose column equals the value.
+	FilterEq FilterOp = iota
+	// FilterIn matches rows whose column equals any of the values.
+	FilterIn
+	// FilterGTE and FilterLTE bound the column inclusively, FilterLT exclusively.
+	FilterGTE
+	FilterLTE
+	FilterLT
+	// FilterNull matches rows whose column is NULL, or is not NULL when the value is false.
+	FilterNull
+)
+
+// Filter narrows a list to rows matching a condition on one column. Columns are
+// trusted identifiers; callers must never take them from user input.
+type Filter struct {
+	Column string
+	Op     FilterOp
+	// Value is a single value, a []interface{} for FilterIn, or a bool for FilterNull.
+	Value interface{}
+}
+
+func (f Filter) expression() clause.Expression {
+	column := clause.Column{Name: f.Column}
+	switch f.Op {
+	case FilterIn:
+		values, _ := f.Value.([]interface{})
+		return clause.IN{Column: column, Values: values}
+	case FilterGTE:
+		return clause.Gte{Column: column, Value: f.Value}
+	case FilterLTE:
+		return clause.Lte{Column: column, Value: f.Value}
+	case FilterLT:
+		return clause.Lt{Column: column, Value: f.Value}
+	case FilterNull:
+		if isNull, _ := f.Value.(bool); !isNull {
+			return clause.Neq{Column: column, Value: nil}
+		}
+		return clause.Eq{Column: column, Value: nil}
+	default:
+		return clause.Eq{Column: column, Value: f.Value}
+	}
+}
+
+// Sort orders a list by a column, with the ID breaking ties. The zero value
+// orders by ID alone. Sort columns must be NOT NULL for cursors to work.
+type Sort struct {
+	Column string
+	Desc   bool
+}
+
+func applyFilters(db *gorm.DB, filters []Filter) *gorm.DB {
+	for _, filter := range filters {
+		db = db.Where(filter.expression())
+	}
+	return db
+}
@@ -1,9 +1,9 @@
 package mysql
 
 import (
+	"fmt"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -22,27 +22,107 @@ func (b *BaseModel) GetID() string {
 	return b.ID
 }
 
+// TimestampPrecision is the number of fractional second digits of the timestamp
+// columns. GORM must be opened with it as DefaultDatetimePrecision, since the
+// MySQL dialector otherwise rounds the times it generates to milliseconds.
+const TimestampPrecision = 6
+
+// Timestamps maps the created_at and updated_at columns of entity tables. Every
+// write moves UpdatedAt forward, so it doubles as the version of the row for
+// optimistic concurrency; the columns keep microseconds for that reason.
+type Timestamps struct {
+	CreatedAt time.Time `json:"created_at" gorm:"<-:create;autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// Version returns the version of the row the model was loaded from.
+func (t *Timestamps) Version() time.Time {
+	return t.UpdatedAt
+}
+
+// Versioned is implemented by models whose rows carry a version.
+type Versioned interface {
+	Version() time.Time
+}
+
 // Entity describes models that expose ULID identifiers.
 type Entity interface {
 	GetID() string
 	SetID(string)
 }
 
+// Validator is implemented by models that check their fields before being persisted.
+type Validator interface {
+	Validate() error
+}
+
+// Role identifies the set of permissions granted to a user.
+type Role string
+
+const (
+	RoleAdmin        Role = "admin"
+	RoleSupervisor   Role = "supervisor"
+	RoleMerchandiser Role = "merchandiser"
+	RoleReadOnly     Role = "read_only"
+)
+
+// Roles lists the known roles.
+var Roles = []Role{RoleAdmin, RoleSupervisor, RoleMerchandiser, RoleReadOnly}
+
+// Valid reports whether the role is one of the known roles.
+func (r Role) Valid() bool {
+	switch r {
+	case RoleAdmin, RoleSupervisor, RoleMerchandiser, RoleReadOnly:
+		return true
+	}
+	return false
+}
+
 type User struct {
 	BaseModel
-	Name         string `json:"name" gorm:"size:255;not null"`
-	Email        string `json:"email" gorm:"size:255;uniqueIndex;not null"`
-	Password     string `json:"password,omitempty" gorm:"-"`
-	PasswordHash string `json:"-" gorm:"column:password;size:255;not null"`
+	Timestamps
+	Name         string  `json:"name" gorm:"size:255;not null"`
+	Email        string  `json:"email" gorm:"size:255;uniqueIndex;not null"`
+	Password     string  `json:"password,omitempty" gorm:"-"`
+	PasswordHash string  `json:"-" gorm:"column:password;size:255;not null"`
+	Role         Role    `json:"role" gorm:"size:32;not null;default:merchandiser"`
+	OIDCSubject  *string `json:"-" gorm:"column:oidc_subject;size:255;uniqueIndex"`
+
+	// Active and DeactivatedAt are only changed through AuthRepository.SetUserActive,
+	// which also revokes the sessions of deactivated users.
+	Active        bool       `json:"active" gorm:"<-:create;not null;default:true"`
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty" gorm:"<-:false"`
+
+	TOTPSecret        *string    `json:"-" gorm:"column:totp_secret;size:64"`
+	TOTPEnabledAt     *time.Time `json:"-" gorm:"column:totp_enabled_at"`
+	TOTPLastStep      int64      `json:"-" gorm:"column:totp_last_step;not null;default:0"`
+	TOTPRecoveryCodes []string   `json:"-" gorm:"column:totp_recovery_codes;serializer:json;type:text"`
+
+	passwordOutdated bool
+}
+
+// TwoFactorEnabled reports whether the user has confirmed TOTP enrollment.
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil && u.TOTPSecret != nil
+}
+
+// ResetTwoFactor removes the user's TOTP enrollment and recovery codes.
+func (u *User) ResetTwoFactor() {
+	u.TOTPSecret = nil
+	u.TOTPEnabledAt = nil
+	u.TOTPLastStep = 0
+	u.TOTPRecoveryCodes = nil
 }
 
 type Company struct {
 	BaseModel
+	Timestamps
 	Name string `json:"name" gorm:"size:255;not null"`
 }
 
 type RetailPoint struct {
 	BaseModel
+	Timestamps
 	CompanyID string `json:"company_id" gorm:"type:char(26);not null"`
 	Name      string `json:"name" gorm:"size:255;not null"`
 	Address   string `json:"address" gorm:"size:512"`
@@ -50,17 +130,20 @@ type RetailPoint struct {
 
 type Brand struct {
 	BaseModel
+	Timestamps
 	Name string `json:"name" gorm:"size:255;not null"`
 }
 
 type Category struct {
 	BaseModel
+	Timestamps
 	Name     string  `json:"name" gorm:"size:255;not null"`
 	ParentID *string `json:"parent_id,omitempty" gorm:"type:char(26);"`
 }
 
 type Product struct {
 	BaseModel
+	Timestamps
 	Name       string  `json:"name" gorm:"size:255;not null"`
 	SKU        *string `json:"sku" gorm:"size:64;uniqueIndex"`
 	BrandID    string  `json:"brand_id" gorm:"type:char(26);not null"`
@@ -69,6 +152,7 @@ type Product struct {
 
 type Visit struct {
 	BaseModel
+	Timestamps
 	UserID        string    `json:"user_id" gorm:"type:char(26);not null"`
 	RetailPointID string    `json:"retail_point_id" gorm:"type:char(26);not null"`
 	VisitedAt     time.Time `json:"visited_at"`
@@ -77,6 +161,7 @@ type Visit struct {
 
 type VisitItem struct {
 	BaseModel
+	Timestamps
 	VisitID         string   `json:"visit_id" gorm:"type:char(26);not null"`
 	ProductID       string   `json:"product_id" gorm:"type:char(26);not null"`
 	PresentQuantity *int     `json:"present_quantity"`
@@ -84,28 +169,303 @@ type VisitItem struct {
 	Price           *float64 `json:"price"`
 }
 
+// UserCompany binds a user to a company whose data they may access.
+type UserCompany struct {
+	UserID    string    `json:"user_id" gorm:"type:char(26);primaryKey"`
+	CompanyID string    `json:"company_id" gorm:"type:char(26);primaryKey"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
 type UserToken struct {
+	BaseModel
+	UserID           string     `json:"user_id" gorm:"type:char(26);not null"`
+	ImpersonatorID   *string    `json:"impersonator_id,omitempty" gorm:"type:char(26)"`
+	Token            string     `json:"token,omitempty" gorm:"-"`
+	TokenHash        string     `json:"-" gorm:"column:token;size:64;uniqueIndex;not null"`
+	RefreshToken     string     `json:"refresh_token,omitempty" gorm:"-"`
+	RefreshTokenHash *string    `json:"-" gorm:"column:refresh_token;size:64;uniqueIndex"`
+	DeviceLabel      string     `json:"device_label,omitempty" gorm:"size:255"`
+	UserAgent        string     `json:"user_agent,omitempty" gorm:"size:512"`
+	IP               string     `json:"ip,omitempty" gorm:"column:ip;size:64"`
+	CreatedAt        time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	LastUsedAt       *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	RefreshExpiresAt *time.Time `json:"refresh_expires_at,omitempty"`
+}
+
+// RevokedSession marks a deleted session whose signed access tokens must be
+// rejected until they expire.
+type RevokedSession struct {
+	SessionID string    `json:"session_id" gorm:"type:char(26);primaryKey"`
+	UserID    string    `json:"user_id" gorm:"type:char(26);not null"`
+	RevokedAt time.Time `json:"revoked_at" gorm:"autoCreateTime"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null"`
+}
+
+// APIKey is a long-lived credential for machine-to-machine integrations. It acts
+// on behalf of its owner, limited to the listed scopes.
+type APIKey struct {
+	BaseModel
+	Name       string     `json:"name" gorm:"size:255;not null"`
+	OwnerID    string     `json:"owner_id" gorm:"type:char(26);not null"`
+	Prefix     string     `json:"prefix" gorm:"size:16;uniqueIndex;not null"`
+	Key        string     `json:"key,omitempty" gorm:"-"`
+	KeyHash    string     `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json;type:text;not null"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// BeforeSave stores the digest of the plain-text key.
+func (k *APIKey) BeforeSave(tx *gorm.DB) error {
+	if k.Key != "" {
+		k.KeyHash = HashToken(k.Key)
+	}
+	return nil
+}
+
+// Active reports whether the key is neither revoked nor expired at the given time.
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || k.ExpiresAt.After(now))
+}
+
+// TwoFactorChallenge is issued after a correct password for users with two-factor
+// authentication enabled and is exchanged, together with a code, for a session.
+type TwoFactorChallenge struct {
+	BaseModel
+	UserID      string    `json:"user_id" gorm:"type:char(26);not null"`
+	Token       string    `json:"-" gorm:"-"`
+	TokenHash   string    `json:"-" gorm:"column:token;size:64;uniqueIndex;not null"`
+	DeviceLabel string    `json:"device_label,omitempty" gorm:"size:255"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+	ExpiresAt   time.Time `json:"expires_at" gorm:"not null"`
+}
+
+// BeforeSave stores the digest of the plain-text challenge token.
+func (t *TwoFactorChallenge) BeforeSave(tx *gorm.DB) error {
+	if t.Token != "" {
+		t.TokenHash = HashToken(t.Token)
+	}
+	return nil
+}
+
+// RolePolicy holds administrator-controlled security settings of a role.
+type RolePolicy struct {
+	Role             Role      `json:"role" gorm:"size:32;primaryKey"`
+	RequireTwoFactor bool      `json:"require_two_factor" gorm:"not null;default:false"`
+	UpdatedAt        time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// Invitation statuses derived from the timestamps of a UserInvitation.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationExpired  = "expired"
+	InvitationRevoked  = "revoked"
+)
+
+// UserInvitation lets an invited user choose their own password. The invited
+// user exists from the start but has no password until the invitation is accepted.
+type UserInvitation struct {
+	BaseModel
+	UserID     *string    `json:"user_id,omitempty" gorm:"type:char(26)"`
+	Email      string     `json:"email" gorm:"size:255;not null"`
+	Token      string     `json:"-" gorm:"-"`
+	TokenHash  string     `json:"-" gorm:"column:token;size:64;uniqueIndex;not null"`
+	InvitedBy  *string    `json:"invited_by,omitempty" gorm:"type:char(26)"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Status reports the state of the invitation at the given time.
+func (i *UserInvitation) Status(now time.Time) string {
+	switch {
+	case i.AcceptedAt != nil:
+		return InvitationAccepted
+	case i.RevokedAt != nil:
+		return InvitationRevoked
+	case !i.ExpiresAt.After(now):
+		return InvitationExpired
+	}
+	return InvitationPending
+}
+
+// BeforeSave stores the digest of the plain-text invitation token.
+func (i *UserInvitation) BeforeSave(tx *gorm.DB) error {
+	if i.Token != "" {
+		i.TokenHash = HashToken(i.Token)
+	}
+	return nil
+}
+
+// InvitationFilter narrows down listed invitations. Empty fields match everything.
+type InvitationFilter struct {
+	Status string
+	Email  string
+	Now    time.Time
+}
+
+// PasswordResetToken is a single-use token allowing a user to choose a new password.
+type PasswordResetToken struct {
 	BaseModel
 	UserID    string     `json:"user_id" gorm:"type:char(26);not null"`
-	Token     string     `json:"token" gorm:"size:64;uniqueIndex;not null"`
+	Token     string     `json:"-" gorm:"-"`
+	TokenHash string     `json:"-" gorm:"column:token;size:64;uniqueIndex;not null"`
 	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
-	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+// BeforeSave stores the digest of the plain-text reset token.
+func (t *PasswordResetToken) BeforeSave(tx *gorm.DB) error {
+	if t.Token != "" {
+		t.TokenHash = HashToken(t.Token)
+	}
+	return nil
+}
+
+// LoginThrottle counts recent failed logins for an email address or client IP.
+type LoginThrottle struct {
+	Kind          string     `json:"kind" gorm:"size:16;primaryKey"`
+	Subject       string     `json:"subject" gorm:"size:255;primaryKey"`
+	Failures      int        `json:"failures" gorm:"not null;default:0"`
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// SecurityEvent records an authentication-related event for auditing.
+type SecurityEvent struct {
+	BaseModel
+	Type      string    `json:"type" gorm:"size:64;not null"`
+	UserID    *string   `json:"user_id,omitempty" gorm:"type:char(26)"`
+	ActorID   *string   `json:"actor_id,omitempty" gorm:"type:char(26)"`
+	Email     string    `json:"email,omitempty" gorm:"size:255"`
+	IP        string    `json:"ip,omitempty" gorm:"column:ip;size:64"`
+	UserAgent string    `json:"user_agent,omitempty" gorm:"size:512"`
+	Detail    string    `json:"detail,omitempty" gorm:"size:255"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// SecurityEventFilter narrows a security event query. Empty fields are ignored.
+type SecurityEventFilter struct {
+	Type   string
+	UserID string
+	Email  string
+	IP     string
+	Since  *time.Time
+	Limit  int
+}
+
+// TokenActivity describes a use of a token that should be persisted.
+type TokenActivity struct {
+	UsedAt    time.Time
+	ExpiresAt *time.Time
+	IP        string
+	UserAgent string
+}
+
+// PurgeResult counts the rows removed by AuthRepository.PurgeExpired.
+type PurgeResult struct {
+	UserTokens          int64
+	RevokedSessions     int64
+	TwoFactorChallenges int64
+	PasswordResetTokens int64
+}
+
+// Total returns the number of rows removed from all tables.
+func (r PurgeResult) Total() int64 {
+	return r.UserTokens + r.RevokedSessions + r.TwoFactorChallenges + r.PasswordResetTokens
+}
+
+// Expired reports whether the access token is no longer valid at the given time.
+func (t *UserToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !t.ExpiresAt.After(now)
 }
 
-// BeforeSave hashes the password if a plain-text password has been provided.
+// Refreshable reports whether the refresh token can still be exchanged at the given time.
+func (t *UserToken) Refreshable(now time.Time) bool {
+	if t.RefreshTokenHash == nil {
+		return false
+	}
+	return t.RefreshExpiresAt == nil || t.RefreshExpiresAt.After(now)
+}
+
+// Validate checks that the user has a known role, defaulting empty roles to merchandiser.
+func (u *User) Validate() error {
+	if u.Role == "" {
+		u.Role = RoleMerchandiser
+	}
+	if !u.Role.Valid() {
+		return fmt.Errorf("unknown role %q", u.Role)
+	}
+	return nil
+}
+
+// derivedColumns reports the password column when BeforeSave is going to hash a
+// new plain-text password into it.
+func (u *User) derivedColumns() []string {
+	if u.Password != "" {
+		return []string{"password"}
+	}
+	return nil
+}
+
+// protectedColumns reports the credential, single sign-on and two-factor columns,
+// which only AuthRepository writes.
+func (u *User) protectedColumns() []string {
+	return []string{"password", "oidc_subject", "totp_secret", "totp_enabled_at", "totp_last_step", "totp_recovery_codes"}
+}
+
+// BeforeSave hashes the password with argon2id if a plain-text password has been provided.
 func (u *User) BeforeSave(tx *gorm.DB) error {
 	if u.Password != "" {
-		hashed, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		hashed, err := hashPassword(u.Password)
 		if err != nil {
 			return err
 		}
-		u.PasswordHash = string(hashed)
+		u.PasswordHash = hashed
 		u.Password = ""
+		u.passwordOutdated = false
+	}
+	return nil
+}
+
+// BeforeSave stores digests of plain-text token values; the plain values are kept on
+// the model so they can be handed to the client once.
+func (t *UserToken) BeforeSave(tx *gorm.DB) error {
+	if t.Token != "" {
+		t.TokenHash = HashToken(t.Token)
+	}
+	if t.RefreshToken != "" {
+		hashed := HashToken(t.RefreshToken)
+		t.RefreshTokenHash = &hashed
 	}
 	return nil
 }
 
-// CheckPassword verifies the provided password against the stored hash.
+// CheckPassword verifies the provided password against the stored hash. Legacy
+// bcrypt hashes are accepted; PasswordNeedsRehash reports them afterwards.
 func (u *User) CheckPassword(plain string) error {
-	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(plain))
+	outdated, err := verifyPassword(u.PasswordHash, plain)
+	if err != nil {
+		return err
+	}
+	u.passwordOutdated = outdated
+	return nil
+}
+
+// HasPassword reports whether the user has chosen a password. Invited users have
+// none until they accept their invitation.
+func (u *User) HasPassword() bool {
+	return u.PasswordHash != ""
+}
+
+// PasswordNeedsRehash reports whether the last successfully checked password is
+// stored with bcrypt or outdated argon2id parameters and should be hashed again.
+func (u *User) PasswordNeedsRehash() bool {
+	return u.passwordOutdated
 }
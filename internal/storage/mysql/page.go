@@ -0,0 +1,161 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrCursorNotFound is returned when a cursor of a sorted list refers to a row
+// that does not exist or is no longer visible.
+var ErrCursorNotFound = errors.New("cursor does not refer to a visible record")
+
+// PageRequest selects a window of a filtered list. Without a sort column the
+// list is ordered by ID; IDs are ULIDs, so the order follows creation time and
+// stays stable while rows are added.
+type PageRequest struct {
+	Limit   int
+	Filters []Filter
+	// Search is a boolean-mode query built by ParseSearch. It only narrows the
+	// list; the order stays that of Sort so that cursors remain valid.
+	Search string
+	Sort   Sort
+	// After and Before are the IDs of the rows bounding the window exclusively.
+	// With only Before set, the rows immediately preceding it are returned.
+	After  string
+	Before string
+	// CountTotal requests the number of visible rows regardless of the bounds.
+	CountTotal bool
+}
+
+// Page describes the window returned by ListPage.
+type Page struct {
+	// Next and Prev are the cursors of the adjacent windows, empty at either end.
+	Next  string
+	Prev  string
+	Total *int64
+}
+
+// ListPage loads one page of the records visible in the context scope that match
+// the request into the destination slice pointer, in the order of req.Sort.
+func (r *Repository) ListPage(ctx context.Context, dest interface{}, req PageRequest) (Page, error) {
+	query, err := r.filtered(ctx, dest, req)
+	if err != nil {
+		return Page{}, err
+	}
+	if req.After != "" {
+		if query, err = r.seek(ctx, query, dest, req.Sort, req.After, false); err != nil {
+			return Page{}, err
+		}
+	}
+	if req.Before != "" {
+		if query, err = r.seek(ctx, query, dest, req.Sort, req.Before, true); err != nil {
+			return Page{}, err
+		}
+	}
+
+	backward := req.Before != "" && req.After == ""
+	desc := req.Sort.Desc != backward
+	if req.Sort.Column != "" {
+		query = query.Order(clause.OrderByColumn{Column: clause.Column{Name: req.Sort.Column}, Desc: desc})
+	}
+	query = query.Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: desc})
+
+	// One extra row tells whether another page follows.
+	if err := query.Limit(req.Limit + 1).Find(dest).Error; err != nil {
+		return Page{}, err
+	}
+
+	rows := reflect.ValueOf(dest).Elem()
+	more := rows.Len() > req.Limit
+	if more {
+		rows.Set(rows.Slice(0, req.Limit))
+	}
+	if backward {
+		swap := reflect.Swapper(rows.Interface())
+		for i, j := 0, rows.Len()-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+
+	var page Page
+	if count := rows.Len(); count > 0 {
+		first, last := rowID(rows, 0), rowID(rows, count-1)
+		switch {
+		case backward:
+			page.Next = last
+			if more {
+				page.Prev = first
+			}
+		default:
+			if more {
+				page.Next = last
+			}
+			if req.After != "" {
+				page.Prev = first
+			}
+		}
+	}
+
+	if req.CountTotal {
+		var total int64
+		counted, err := r.filtered(ctx, dest, req)
+		if err != nil {
+			return Page{}, err
+		}
+		if err := counted.Model(dest).Count(&total).Error; err != nil {
+			return Page{}, err
+		}
+		page.Total = &total
+	}
+	return page, nil
+}
+
+// filtered starts a query on the rows visible in the context scope that match
+// the filters and the search of the request.
+func (r *Repository) filtered(ctx context.Context, dest interface{}, req PageRequest) (*gorm.DB, error) {
+	query := applyFilters(scoped(ctx, r.db.WithContext(ctx), dest), req.Filters)
+	if req.Search == "" {
+		return query, nil
+	}
+	model, ok := elemModel(dest).(Searchable)
+	if !ok {
+		return nil, errors.New("records of this type cannot be searched")
+	}
+	return query.Where(matchExpression(model), req.Search), nil
+}
+
+// seek keeps the rows that follow the cursor row in the sort order, or that
+// precede it when before is set.
+func (r *Repository) seek(ctx context.Context, query *gorm.DB, dest interface{}, sort Sort, cursor string, before bool) (*gorm.DB, error) {
+	op := ">"
+	if sort.Desc != before {
+		op = "<"
+	}
+	if sort.Column == "" {
+		return query.Where("id "+op+" ?", cursor), nil
+	}
+
+	column := clause.Column{Name: sort.Column}
+	var value interface{}
+	err := scoped(ctx, r.db.WithContext(ctx), dest).Model(dest).
+		Select("?", column).Where("id = ?", cursor).Row().Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCursorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return query.Where("? "+op+" ? OR (? = ? AND id "+op+" ?)", column, value, column, value, cursor), nil
+}
+
+func rowID(rows reflect.Value, i int) string {
+	if entity, ok := rows.Index(i).Addr().Interface().(Entity); ok {
+		return entity.GetID()
+	}
+	return ""
+}
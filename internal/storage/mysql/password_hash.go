@@ -0,0 +1,112 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrPasswordMismatch is returned when a password does not match the stored hash.
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// argon2Params are the argon2id cost parameters encoded into every hash.
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	saltLen uint32
+	keyLen  uint32
+}
+
+// passwordHashParams follow the second recommended option of RFC 9106. Hashes
+// created with other parameters are upgraded on the next successful login.
+var passwordHashParams = argon2Params{
+	memory:  64 * 1024,
+	time:    3,
+	threads: 2,
+	saltLen: 16,
+	keyLen:  32,
+}
+
+// hashPassword derives an argon2id hash in the PHC string format
+// $argon2id$v=19$m=...,t=...,p=...$salt$key.
+func hashPassword(plain string) (string, error) {
+	params := passwordHashParams
+	salt := make([]byte, params.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(plain), salt, params.time, params.memory, params.threads, params.keyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.memory, params.time, params.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// verifyPassword compares a password with an argon2id or legacy bcrypt hash and
+// reports whether the hash should be replaced by one with the current parameters.
+func verifyPassword(hash, plain string) (needsRehash bool, err error) {
+	if hash == "" {
+		return false, ErrPasswordMismatch
+	}
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, ErrPasswordMismatch
+			}
+			return false, err
+		}
+		return true, nil
+	}
+
+	params, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	candidate := argon2.IDKey([]byte(plain), salt, params.time, params.memory, params.threads, params.keyLen)
+	if subtle.ConstantTimeCompare(candidate, key) != 1 {
+		return false, ErrPasswordMismatch
+	}
+
+	current := passwordHashParams
+	return params.memory != current.memory || params.time != current.time || params.threads != current.threads ||
+		params.keyLen != current.keyLen || params.saltLen != current.saltLen, nil
+}
+
+func decodeArgon2Hash(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id key: %w", err)
+	}
+	params.saltLen = uint32(len(salt))
+	params.keyLen = uint32(len(key))
+
+	return params, salt, key, nil
+}
@@ -2,10 +2,18 @@ package mysql
 
 import (
 	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// ErrVersionMismatch is returned when a conditional write finds the row at a
+// version other than the one the caller expected, or finds it gone.
+var ErrVersionMismatch = errors.New("record has been modified or deleted by another request")
+
 // Repository exposes helpers for CRUD operations on the MySQL storage.
 type Repository struct {
 	db *gorm.DB
@@ -21,27 +29,130 @@ func (r *Repository) DB() *gorm.DB {
 	return r.db
 }
 
-// Create inserts the provided entity.
+// Create inserts the provided entity after checking it against the context scope.
 func (r *Repository) Create(ctx context.Context, entity interface{}) error {
-	return r.db.WithContext(ctx).Create(entity).Error
+	db := r.db.WithContext(ctx)
+	if err := checkScope(ctx, db, entity); err != nil {
+		return err
+	}
+	return db.Create(entity).Error
 }
 
-// Update persists changes of the provided entity.
-func (r *Repository) Update(ctx context.Context, entity interface{}) error {
-	return r.db.WithContext(ctx).Save(entity).Error
+// Update persists all columns of the provided entity except its protected ones
+// after checking it against the context scope. With a version, the row is only
+// written while it is still at that version.
+func (r *Repository) Update(ctx context.Context, entity interface{}, version *time.Time) error {
+	db := r.db.WithContext(ctx)
+	if err := checkScope(ctx, db, entity); err != nil {
+		return err
+	}
+	query := atVersion(db.Model(entity), version).Select("*")
+	if omitted := omittedColumns(entity); len(omitted) > 0 {
+		query = query.Omit(omitted...)
+	}
+	return checkVersion(query.Updates(entity), version)
 }
 
-// FindByID loads a single entity by ULID.
+// FindByID loads a single entity by ULID within the context scope.
 func (r *Repository) FindByID(ctx context.Context, dest interface{}, id string) error {
-	return r.db.WithContext(ctx).First(dest, "id = ?", id).Error
+	db := r.db.WithContext(ctx)
+	return scoped(ctx, db, dest).First(dest, "id = ?", id).Error
+}
+
+// DeleteByID removes an entity by its ULID when it is visible in the context scope
+// and, given a version, still at that version.
+func (r *Repository) DeleteByID(ctx context.Context, model interface{}, id string, version *time.Time) error {
+	db := r.db.WithContext(ctx)
+	return checkVersion(atVersion(scoped(ctx, db, model).Where("id = ?", id), version).Delete(model), version)
+}
+
+// atVersion restricts a write to rows at the version, if any.
+func atVersion(db *gorm.DB, version *time.Time) *gorm.DB {
+	if version == nil {
+		return db
+	}
+	return db.Where("updated_at = ?", *version)
+}
+
+// checkVersion reports a conditional write that matched no row as ErrVersionMismatch.
+func checkVersion(result *gorm.DB, version *time.Time) error {
+	if result.Error != nil {
+		return result.Error
+	}
+	if version != nil && result.RowsAffected == 0 {
+		return ErrVersionMismatch
+	}
+	return nil
 }
 
-// List returns all records for the given destination slice pointer.
-func (r *Repository) List(ctx context.Context, dest interface{}) error {
-	return r.db.WithContext(ctx).Find(dest).Error
+// derivedColumns is implemented by models whose save hooks fill columns from
+// fields that are not stored themselves, such as the plain-text user password.
+type derivedColumns interface {
+	derivedColumns() []string
 }
 
-// DeleteByID removes an entity by its ULID.
-func (r *Repository) DeleteByID(ctx context.Context, model interface{}, id string) error {
-	return r.db.WithContext(ctx).Where("id = ?", id).Delete(model).Error
+// protectedColumns is implemented by models with columns that only dedicated
+// repository methods may write, such as credentials and two-factor state, so that
+// generic updates never write back a stale copy of them.
+type protectedColumns interface {
+	protectedColumns() []string
+}
+
+// omittedColumns lists the protected columns of the entity that a generic update
+// must leave alone. Columns its save hooks are about to fill are written anyway.
+func omittedColumns(entity interface{}) []string {
+	protected, ok := entity.(protectedColumns)
+	if !ok {
+		return nil
+	}
+	var derived []string
+	if d, ok := entity.(derivedColumns); ok {
+		derived = d.derivedColumns()
+	}
+	var omitted []string
+	for _, column := range protected.protectedColumns() {
+		if !slices.Contains(derived, column) {
+			omitted = append(omitted, column)
+		}
+	}
+	return omitted
+}
+
+// Patch writes only the columns of the entity that differ from original, a copy
+// of the entity taken before it was changed, after checking the entity against
+// the context scope. Nothing is written when no column changed. With a version,
+// the row is only written while it is still at that version.
+func (r *Repository) Patch(ctx context.Context, entity, original interface{}, version *time.Time) error {
+	db := r.db.WithContext(ctx)
+	if err := checkScope(ctx, db, entity); err != nil {
+		return err
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(entity); err != nil {
+		return err
+	}
+
+	before := reflect.Indirect(reflect.ValueOf(original))
+	after := reflect.Indirect(reflect.ValueOf(entity))
+	omitted := omittedColumns(entity)
+	var columns []string
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" || field.PrimaryKey || !field.Updatable || slices.Contains(omitted, field.DBName) {
+			continue
+		}
+		oldValue := field.ReflectValueOf(ctx, before).Interface()
+		newValue := field.ReflectValueOf(ctx, after).Interface()
+		if !reflect.DeepEqual(oldValue, newValue) {
+			columns = append(columns, field.DBName)
+		}
+	}
+	if derived, ok := entity.(derivedColumns); ok {
+		columns = append(columns, derived.derivedColumns()...)
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	return checkVersion(atVersion(db.Model(entity), version).Select(columns).Updates(entity), version)
 }
@@ -0,0 +1,167 @@
+package mysql
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	gmysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// dryRunDB opens a GORM connection that builds statements without running them
+// and records the SQL of every update it builds.
+func dryRunDB(t *testing.T) (*gorm.DB, *[]string) {
+	t.Helper()
+	db, err := gorm.Open(gmysql.New(gmysql.Config{DSN: "merch:merch@tcp(127.0.0.1:1)/merch", SkipInitializeWithVersion: true}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var statements []string
+	if err := db.Callback().Update().After("gorm:update").Register("test:record", func(tx *gorm.DB) {
+		statements = append(statements, tx.Statement.SQL.String())
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return db, &statements
+}
+
+// assignedColumns returns the columns in the SET clause of an UPDATE statement.
+func assignedColumns(t *testing.T, statement string) []string {
+	t.Helper()
+	_, set, ok := strings.Cut(statement, " SET ")
+	if !ok {
+		t.Fatalf("statement %q has no SET clause", statement)
+	}
+	set, _, _ = strings.Cut(set, " WHERE ")
+
+	var columns []string
+	for _, assignment := range strings.Split(set, ",") {
+		column, _, _ := strings.Cut(strings.TrimSpace(assignment), "=")
+		columns = append(columns, strings.Trim(column, "` "))
+	}
+	return columns
+}
+
+func storedUser() *User {
+	secret := "JBSWY3DPEHPK3PXP"
+	subject := "subject"
+	enabledAt := time.Unix(1700000000, 0)
+	user := &User{
+		Name:              "Ivan",
+		Email:             "ivan@example.com",
+		PasswordHash:      "$argon2id$stored",
+		Role:              RoleSupervisor,
+		Active:            true,
+		OIDCSubject:       &subject,
+		TOTPSecret:        &secret,
+		TOTPEnabledAt:     &enabledAt,
+		TOTPLastStep:      42,
+		TOTPRecoveryCodes: []string{"used-code", "spare-code"},
+	}
+	user.SetID("01HZY3S0MS1G5B2TQ4V6W8X0YZ")
+	return user
+}
+
+func TestUpdateLeavesProtectedUserColumns(t *testing.T) {
+	protected := (&User{}).protectedColumns()
+
+	tests := []struct {
+		name         string
+		password     string
+		wantPassword bool
+	}{
+		{name: "without password"},
+		{name: "with new password", password: "new-secret-42", wantPassword: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, statements := dryRunDB(t)
+			user := storedUser()
+			user.Name, user.Password = "Ivan Petrov", tt.password
+
+			if err := NewRepository(db).Update(context.Background(), user, nil); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if len(*statements) != 1 {
+				t.Fatalf("built %d statements, want 1", len(*statements))
+			}
+			columns := assignedColumns(t, (*statements)[0])
+
+			if !slices.Contains(columns, "name") || !slices.Contains(columns, "role") {
+				t.Fatalf("update %v misses the columns the body sets", columns)
+			}
+			for _, column := range protected {
+				want := column == "password" && tt.wantPassword
+				if slices.Contains(columns, column) != want {
+					t.Fatalf("update %v writes %s = %v, want %v", columns, column, !want, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPatchWritesChangedColumns(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*User)
+		want   []string
+	}{
+		{
+			name:   "nothing changed",
+			change: func(*User) {},
+		},
+		{
+			name:   "name",
+			change: func(u *User) { u.Name = "Ivan Petrov" },
+			want:   []string{"updated_at", "name"},
+		},
+		{
+			name:   "password only",
+			change: func(u *User) { u.Password = "new-secret-42" },
+			want:   []string{"updated_at", "password"},
+		},
+		{
+			name:   "stale two-factor state",
+			change: func(u *User) { u.TOTPRecoveryCodes = []string{"used-code", "spare-code", "third-code"} },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, statements := dryRunDB(t)
+			user := storedUser()
+			original := *user
+			tt.change(user)
+
+			if err := NewRepository(db).Patch(context.Background(), user, &original, nil); err != nil {
+				t.Fatalf("Patch: %v", err)
+			}
+			if len(tt.want) == 0 {
+				if len(*statements) != 0 {
+					t.Fatalf("built %q, want no statement", *statements)
+				}
+				return
+			}
+			if len(*statements) != 1 {
+				t.Fatalf("built %d statements, want 1", len(*statements))
+			}
+			if columns := assignedColumns(t, (*statements)[0]); !slices.Equal(columns, tt.want) {
+				t.Fatalf("patch writes %v, want %v", columns, tt.want)
+			}
+			if slices.Contains(tt.want, "password") && (user.PasswordHash == original.PasswordHash || !strings.HasPrefix(user.PasswordHash, "$argon2id$")) {
+				t.Fatalf("password stored as %q, want a new argon2id hash", user.PasswordHash)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// ErrOutOfScope is returned when a record is outside of the caller's data scope.
+var ErrOutOfScope = errors.New("record is outside of the caller's scope")
+
+// Scope restricts which rows a caller may read and write. The zero value is unrestricted.
+type Scope struct {
+	// UserID limits user-owned records such as visits to the given user.
+	UserID string
+	// CompanyIDs limits company-bound records to the given companies when not empty.
+	CompanyIDs []string
+}
+
+// Scoped is implemented by models whose visibility depends on the caller's Scope.
+type Scoped interface {
+	// ApplyScope narrows a query on the model's table to rows visible in the scope.
+	ApplyScope(db *gorm.DB, scope Scope) *gorm.DB
+	// CheckScope verifies that the model may be written within the scope.
+	CheckScope(db *gorm.DB, scope Scope) error
+}
+
+type scopeKey struct{}
+
+// WithScope returns a context carrying the data scope of the caller.
+func WithScope(ctx context.Context, scope Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// ScopeFromContext returns the data scope stored in the context, if any.
+func ScopeFromContext(ctx context.Context) Scope {
+	scope, _ := ctx.Value(scopeKey{}).(Scope)
+	return scope
+}
+
+// HasCompany reports whether the scope grants access to the company.
+func (s Scope) HasCompany(companyID string) bool {
+	if len(s.CompanyIDs) == 0 {
+		return true
+	}
+	for _, id := range s.CompanyIDs {
+		if id == companyID {
+			return true
+		}
+	}
+	return false
+}
+
+// scoped applies the context scope to a query on the model when it implements Scoped.
+// The model may be a pointer to a struct or a pointer to a slice of structs.
+func scoped(ctx context.Context, db *gorm.DB, model interface{}) *gorm.DB {
+	if target, ok := scopedModel(model); ok {
+		return target.ApplyScope(db, ScopeFromContext(ctx))
+	}
+	return db
+}
+
+// checkScope verifies that a model being written is inside the context scope.
+func checkScope(ctx context.Context, db *gorm.DB, model interface{}) error {
+	if target, ok := model.(Scoped); ok {
+		return target.CheckScope(db, ScopeFromContext(ctx))
+	}
+	return nil
+}
+
+func scopedModel(model interface{}) (Scoped, bool) {
+	if target, ok := model.(Scoped); ok {
+		return target, true
+	}
+	target, ok := elemModel(model).(Scoped)
+	return target, ok
+}
+
+// elemModel returns a new pointer to the struct type underlying a pointer to a
+// struct or to a slice of structs, or nil for other types.
+func elemModel(model interface{}) interface{} {
+	typ := reflect.TypeOf(model)
+	for typ != nil && (typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Slice) {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil
+	}
+	return reflect.New(typ).Interface()
+}
+
+// ApplyScope limits companies to those the caller is bound to.
+func (c *Company) ApplyScope(db *gorm.DB, scope Scope) *gorm.DB {
+	if len(scope.CompanyIDs) > 0 {
+		db = db.Where("companies.id IN ?", scope.CompanyIDs)
+	}
+	return db
+}
+
+// CheckScope rejects changes to companies the caller is not bound to.
+func (c *Company) CheckScope(db *gorm.DB, scope Scope) error {
+	if !scope.HasCompany(c.ID) {
+		return ErrOutOfScope
+	}
+	return nil
+}
+
+// ApplyScope limits retail points to the caller's companies.
+func (p *RetailPoint) ApplyScope(db *gorm.DB, scope Scope) *gorm.DB {
+	if len(scope.CompanyIDs) > 0 {
+		db = db.Where("retail_points.company_id IN ?", scope.CompanyIDs)
+	}
+	return db
+}
+
+// CheckScope rejects retail points that belong to companies outside of the scope.
+func (p *RetailPoint) CheckScope(db *gorm.DB, scope Scope) error {
+	if !scope.HasCompany(p.CompanyID) {
+		return ErrOutOfScope
+	}
+	return nil
+}
+
+// ApplyScope limits visits to the caller's own visits at the caller's companies.
+func (v *Visit) ApplyScope(db *gorm.DB, scope Scope) *gorm.DB {
+	if scope.UserID != "" {
+		db = db.Where("visits.user_id = ?", scope.UserID)
+	}
+	if len(scope.CompanyIDs) > 0 {
+		db = db.Where("visits.retail_point_id IN (?)", db.Session(&gorm.Session{NewDB: true}).
+			Model(&RetailPoint{}).Select("id").Where("company_id IN ?", scope.CompanyIDs))
+	}
+	return db
+}
+
+// CheckScope assigns new visits to the scoped user and rejects visits of other
+// users or at retail points outside of the caller's companies.
+func (v *Visit) CheckScope(db *gorm.DB, scope Scope) error {
+	if scope.UserID != "" {
+		if v.UserID == "" {
+			v.UserID = scope.UserID
+		}
+		if v.UserID != scope.UserID {
+			return ErrOutOfScope
+		}
+	}
+	if len(scope.CompanyIDs) > 0 {
+		var count int64
+		if err := db.Model(&RetailPoint{}).
+			Where("id = ? AND company_id IN ?", v.RetailPointID, scope.CompanyIDs).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrOutOfScope
+		}
+	}
+	return nil
+}
+
+// ApplyScope limits visit items to those of visits visible in the scope.
+func (i *VisitItem) ApplyScope(db *gorm.DB, scope Scope) *gorm.DB {
+	if scope.UserID == "" && len(scope.CompanyIDs) == 0 {
+		return db
+	}
+	visits := (&Visit{}).ApplyScope(db.Session(&gorm.Session{NewDB: true}).Model(&Visit{}).Select("visits.id"), scope)
+	return db.Where("visit_items.visit_id IN (?)", visits)
+}
+
+// CheckScope rejects visit items attached to visits outside of the scope.
+func (i *VisitItem) CheckScope(db *gorm.DB, scope Scope) error {
+	if scope.UserID == "" && len(scope.CompanyIDs) == 0 {
+		return nil
+	}
+	var count int64
+	if err := (&Visit{}).ApplyScope(db.Model(&Visit{}), scope).
+		Where("visits.id = ?", i.VisitID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrOutOfScope
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// minSearchWordLength matches the default ngram_token_size: shorter words
+	// produce no tokens and cannot be found through the ngram FULLTEXT indexes.
+	minSearchWordLength = 2
+	maxSearchWords      = 8
+)
+
+// ErrSearchTooShort is returned for search text without a word long enough to be looked up.
+var ErrSearchTooShort = errors.New("search text must contain a word of at least 2 characters")
+
+// Searchable is implemented by models with a FULLTEXT index. The indexes use
+// the ngram parser, which splits text into character pairs regardless of the
+// alphabet, so that Cyrillic names and address fragments match as substrings.
+type Searchable interface {
+	// SearchColumns lists the columns of the FULLTEXT index in index order.
+	SearchColumns() []string
+}
+
+// SearchColumns returns the columns of the companies FULLTEXT index.
+func (c *Company) SearchColumns() []string { return []string{"name"} }
+
+// SearchColumns returns the columns of the retail_points FULLTEXT index.
+func (p *RetailPoint) SearchColumns() []string { return []string{"name", "address"} }
+
+// SearchColumns returns the columns of the brands FULLTEXT index.
+func (b *Brand) SearchColumns() []string { return []string{"name"} }
+
+// SearchColumns returns the columns of the products FULLTEXT index.
+func (p *Product) SearchColumns() []string { return []string{"name", "sku"} }
+
+// ParseSearch turns free text into a boolean-mode query that requires every
+// word as a phrase. Operators typed by the user are taken literally.
+func ParseSearch(text string) (string, error) {
+	var terms []string
+	for _, word := range strings.Fields(strings.ReplaceAll(text, `"`, " ")) {
+		if utf8.RuneCountInString(word) < minSearchWordLength {
+			continue
+		}
+		terms = append(terms, `+"`+word+`"`)
+		if len(terms) == maxSearchWords {
+			break
+		}
+	}
+	if len(terms) == 0 {
+		return "", ErrSearchTooShort
+	}
+	return strings.Join(terms, " "), nil
+}
+
+// matchExpression returns the MATCH condition for the model's FULLTEXT index
+// with a placeholder for a query built by ParseSearch.
+func matchExpression(model Searchable) string {
+	columns := model.SearchColumns()
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = "`" + column + "`"
+	}
+	return fmt.Sprintf("MATCH(%s) AGAINST(? IN BOOLEAN MODE)", strings.Join(quoted, ","))
+}
+
+// SearchTarget describes an entity type taking part in Repository.Search.
+type SearchTarget struct {
+	// Type names the entity in the hits, e.g. "product".
+	Type  string
+	Model Searchable
+	// DetailColumn optionally selects a column shown next to the name.
+	DetailColumn string
+}
+
+// SearchHit is a ranked match of Repository.Search.
+type SearchHit struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	Detail *string `json:"detail,omitempty"`
+	Score  float64 `json:"score"`
+}
+
+// SearchGroup holds the hits of one target ordered by relevance. Scores depend
+// on the word statistics of each table, so they only compare hits of one group.
+type SearchGroup struct {
+	Type string      `json:"type"`
+	Hits []SearchHit `json:"hits"`
+}
+
+// Search looks up records of the targets visible in the context scope and
+// returns a group of at most limit hits per target, in the order of targets.
+func (r *Repository) Search(ctx context.Context, query string, targets []SearchTarget, limit int) ([]SearchGroup, error) {
+	db := r.db.WithContext(ctx)
+
+	groups := make([]SearchGroup, 0, len(targets))
+	for _, target := range targets {
+		match := matchExpression(target.Model)
+		detail := "NULL"
+		if target.DetailColumn != "" {
+			detail = "`" + target.DetailColumn + "`"
+		}
+
+		hits := []SearchHit{}
+		if err := scoped(ctx, db, target.Model).Model(target.Model).
+			Select("id, name, "+detail+" AS detail, "+match+" AS score", query).
+			Where(match, query).
+			Order("score DESC").
+			Limit(limit).
+			Scan(&hits).Error; err != nil {
+			return nil, err
+		}
+		groups = append(groups, SearchGroup{Type: target.Type, Hits: hits})
+	}
+	return groups, nil
+}
@@ -0,0 +1,206 @@
+package mysql
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TokenCache is a bounded LRU of token lookups with a short time to live. It
+// spares the token and user queries of FindUserToken for clients that poll.
+//
+// Entries are dropped when the token or user row is updated or deleted through
+// any GORM call on the database the cache is registered with. Other server instances only notice such changes
+// once their entries expire, so the TTL bounds how long a revoked token may still
+// be accepted elsewhere.
+type TokenCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	byID    map[string]string
+	byUser  map[string]map[string]struct{}
+}
+
+type tokenCacheEntry struct {
+	key      string
+	token    UserToken
+	user     User
+	cachedAt time.Time
+}
+
+// NewTokenCache constructs a cache holding at most size lookups for ttl each.
+func NewTokenCache(size int, ttl time.Duration) *TokenCache {
+	return &TokenCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		byID:    make(map[string]string),
+		byUser:  make(map[string]map[string]struct{}),
+	}
+}
+
+// tokenCacheManaged marks statements of the AuthRepository, which invalidates the
+// cache itself once the change is committed, so that the callbacks skip them.
+const tokenCacheManaged = "token_cache:managed"
+
+// Register installs GORM callbacks that drop the cached lookups of tokens and
+// users whose rows are updated or deleted. Statements that do not identify a
+// single row, such as updates by condition, flush the whole cache.
+func (c *TokenCache) Register(db *gorm.DB) error {
+	invalidate := func(tx *gorm.DB) {
+		if tx.Error != nil || tx.Statement.Schema == nil {
+			return
+		}
+		if _, managed := tx.Get(tokenCacheManaged); managed {
+			return
+		}
+
+		var invalidateID func(string)
+		switch tx.Statement.Schema.ModelType {
+		case reflect.TypeOf(User{}):
+			invalidateID = c.InvalidateUser
+		case reflect.TypeOf(UserToken{}):
+			invalidateID = func(id string) { c.InvalidateTokens(id) }
+		default:
+			return
+		}
+		if id := statementID(tx.Statement); id != "" {
+			invalidateID(id)
+			return
+		}
+		c.Flush()
+	}
+
+	if err := db.Callback().Update().After("gorm:update").Register("token_cache:update", invalidate); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:delete").Register("token_cache:delete", invalidate)
+}
+
+// statementID returns the ID of the single model a statement was given, if any.
+func statementID(stmt *gorm.Statement) string {
+	value := stmt.ReflectValue
+	for value.Kind() == reflect.Pointer {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct || !value.CanAddr() {
+		return ""
+	}
+	if entity, ok := value.Addr().Interface().(Entity); ok {
+		return entity.GetID()
+	}
+	return ""
+}
+
+// get returns copies of a cached token and user, so callers may modify them freely.
+func (c *TokenCache) get(key string, now time.Time) (*UserToken, *User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	entry := element.Value.(*tokenCacheEntry)
+	if now.Sub(entry.cachedAt) >= c.ttl {
+		c.remove(element)
+		return nil, nil, false
+	}
+
+	c.order.MoveToFront(element)
+	token, user := entry.token, entry.user
+	return &token, &user, true
+}
+
+func (c *TokenCache) put(key string, token *UserToken, user *User, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	entry := &tokenCacheEntry{key: key, token: *token, user: *user, cachedAt: now}
+	c.entries[key] = c.order.PushFront(entry)
+	c.byID[token.ID] = key
+	if c.byUser[user.ID] == nil {
+		c.byUser[user.ID] = make(map[string]struct{})
+	}
+	c.byUser[user.ID][key] = struct{}{}
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// touch applies recorded token activity to the cached copy.
+func (c *TokenCache) touch(id string, activity TokenActivity) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[c.byID[id]]
+	if !ok {
+		return
+	}
+	entry := element.Value.(*tokenCacheEntry)
+	usedAt := activity.UsedAt
+	entry.token.LastUsedAt = &usedAt
+	entry.token.ExpiresAt = activity.ExpiresAt
+	entry.token.IP = activity.IP
+	entry.token.UserAgent = activity.UserAgent
+}
+
+// InvalidateTokens drops the cached lookups of the tokens with the given IDs.
+func (c *TokenCache) InvalidateTokens(ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		if element, ok := c.entries[c.byID[id]]; ok {
+			c.remove(element)
+		}
+	}
+}
+
+// InvalidateUser drops every cached lookup of the user's tokens.
+func (c *TokenCache) InvalidateUser(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.byUser[userID] {
+		if element, ok := c.entries[key]; ok {
+			c.remove(element)
+		}
+	}
+}
+
+// Flush drops every cached lookup.
+func (c *TokenCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.byID = make(map[string]string)
+	c.byUser = make(map[string]map[string]struct{})
+}
+
+func (c *TokenCache) remove(element *list.Element) {
+	entry := element.Value.(*tokenCacheEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.key)
+	delete(c.byID, entry.token.ID)
+	if keys := c.byUser[entry.user.ID]; keys != nil {
+		delete(keys, entry.key)
+		if len(keys) == 0 {
+			delete(c.byUser, entry.user.ID)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package mysql
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func cachedLookup(tokenID, userID string) (*UserToken, *User) {
+	token := &UserToken{UserID: userID}
+	token.SetID(tokenID)
+	user := &User{Name: userID, Role: RoleMerchandiser}
+	user.SetID(userID)
+	return token, user
+}
+
+// fillCache caches a lookup per token under the token ID as key, so that tests
+// can name entries by token.
+func fillCache(cache *TokenCache, now time.Time, tokens map[string]string) {
+	for tokenID, userID := range tokens {
+		token, user := cachedLookup(tokenID, userID)
+		cache.put(tokenID, token, user, now)
+	}
+}
+
+func assertCached(t *testing.T, cache *TokenCache, now time.Time, want map[string]bool) {
+	t.Helper()
+	for key, cached := range want {
+		if _, _, ok := cache.get(key, now); ok != cached {
+			t.Fatalf("token %s cached = %v, want %v", key, ok, cached)
+		}
+	}
+}
+
+func TestTokenCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cache := NewTokenCache(2, time.Minute)
+	fillCache(cache, now, map[string]string{"t1": "u1"})
+	fillCache(cache, now, map[string]string{"t2": "u1"})
+
+	// Reading t1 makes t2 the least recently used entry.
+	assertCached(t, cache, now, map[string]bool{"t1": true})
+	fillCache(cache, now, map[string]string{"t3": "u2"})
+	assertCached(t, cache, now, map[string]bool{"t1": true, "t2": false, "t3": true})
+
+	// Caching a key again replaces the entry instead of taking another slot.
+	fillCache(cache, now, map[string]string{"t3": "u2"})
+	assertCached(t, cache, now, map[string]bool{"t1": true, "t3": true})
+
+	if len(cache.entries) != 2 || len(cache.byID) != 2 || len(cache.byUser["u1"]) != 1 {
+		t.Fatalf("indexes keep evicted entries: %d entries, %d IDs, %d keys of u1", len(cache.entries), len(cache.byID), len(cache.byUser["u1"]))
+	}
+}
+
+func TestTokenCacheExpiresEntries(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cache := NewTokenCache(10, 30*time.Second)
+	fillCache(cache, now, map[string]string{"t1": "u1"})
+
+	assertCached(t, cache, now.Add(29*time.Second), map[string]bool{"t1": true})
+	// Reads and touches do not extend the time to live.
+	cache.touch("t1", TokenActivity{UsedAt: now.Add(29 * time.Second)})
+	assertCached(t, cache, now.Add(30*time.Second), map[string]bool{"t1": false})
+	if len(cache.entries) != 0 || len(cache.byUser) != 0 {
+		t.Fatal("expired entry is still indexed")
+	}
+}
+
+func TestTokenCacheReturnsCopies(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cache := NewTokenCache(10, time.Minute)
+	fillCache(cache, now, map[string]string{"t1": "u1"})
+
+	token, user, _ := cache.get("t1", now)
+	token.UserID, user.Role = "u2", RoleAdmin
+
+	token, user, _ = cache.get("t1", now)
+	if token.UserID != "u1" || user.Role != RoleMerchandiser {
+		t.Fatalf("cached lookup changed through a returned copy: %+v, %+v", token, user)
+	}
+}
+
+func TestTokenCacheInvalidatesOnWrites(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cached := map[string]string{"t1": "u1", "t2": "u1", "t3": "u2"}
+
+	tests := []struct {
+		name  string
+		write func(db *gorm.DB) error
+		want  map[string]bool
+	}{
+		{
+			name: "user saved",
+			write: func(db *gorm.DB) error {
+				_, user := cachedLookup("", "u1")
+				return db.Model(user).Update("name", "Ivan").Error
+			},
+			want: map[string]bool{"t1": false, "t2": false, "t3": true},
+		},
+		{
+			name: "user deleted",
+			write: func(db *gorm.DB) error {
+				_, user := cachedLookup("", "u2")
+				return db.Delete(user).Error
+			},
+			want: map[string]bool{"t1": true, "t2": true, "t3": false},
+		},
+		{
+			name: "users updated by condition",
+			write: func(db *gorm.DB) error {
+				return db.Model(&User{}).Where("role = ?", RoleMerchandiser).Update("role", RoleReadOnly).Error
+			},
+			want: map[string]bool{"t1": false, "t2": false, "t3": false},
+		},
+		{
+			name: "token deleted",
+			write: func(db *gorm.DB) error {
+				token, _ := cachedLookup("t2", "u1")
+				return db.Delete(token).Error
+			},
+			want: map[string]bool{"t1": true, "t2": false, "t3": true},
+		},
+		{
+			name: "tokens updated by condition",
+			write: func(db *gorm.DB) error {
+				return db.Model(&UserToken{}).Where("user_id = ?", "u2").Update("ip", "10.0.0.1").Error
+			},
+			want: map[string]bool{"t1": false, "t2": false, "t3": false},
+		},
+		{
+			name: "token write invalidated by the repository",
+			write: func(db *gorm.DB) error {
+				return db.Set(tokenCacheManaged, true).Where("id IN ?", []string{"t1"}).Delete(&UserToken{}).Error
+			},
+			want: map[string]bool{"t1": true, "t2": true, "t3": true},
+		},
+		{
+			name: "other model",
+			write: func(db *gorm.DB) error {
+				company := &Company{Name: "Acme"}
+				company.SetID("c1")
+				return db.Model(company).Update("name", "Acme Ltd").Error
+			},
+			want: map[string]bool{"t1": true, "t2": true, "t3": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := dryRunDB(t)
+			cache := NewTokenCache(10, time.Minute)
+			if err := cache.Register(db); err != nil {
+				t.Fatal(err)
+			}
+			fillCache(cache, now, cached)
+
+			if err := tt.write(db); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			assertCached(t, cache, now, tt.want)
+		})
+	}
+}
+
+func TestTokenCacheInvalidateByID(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cache := NewTokenCache(10, time.Minute)
+	fillCache(cache, now, map[string]string{"t1": "u1", "t2": "u1", "t3": "u2"})
+
+	cache.InvalidateTokens("t1", "unknown")
+	assertCached(t, cache, now, map[string]bool{"t1": false, "t2": true, "t3": true})
+	cache.InvalidateUser("u2")
+	assertCached(t, cache, now, map[string]bool{"t2": true, "t3": false})
+	cache.Flush()
+	assertCached(t, cache, now, map[string]bool{"t2": false})
+}
@@ -13,6 +13,12 @@ var (
 	entropy     *ulid.MonotonicEntropy
 )
 
+// ValidID reports whether the value is a well-formed ULID.
+func ValidID(value string) bool {
+	_, err := ulid.ParseStrict(value)
+	return err == nil
+}
+
 // NewID generates a monotonic ULID suitable for use as a primary key.
 func NewID() string {
 	entropyOnce.Do(func() {
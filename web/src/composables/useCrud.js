@@ -1,21 +1,55 @@
-import { ref } from 'vue';
+import { computed, ref, watch } from 'vue';
 import { useToast } from 'primevue/usetoast';
-import api from '../services/api';
+import api, { fetchAll, nextCursor } from '../services/api';
 
 export function useCrud(endpoint, createDefault, options = {}) {
-  const { preparePayload } = options;
+  // loadAll fetches every page at once, for views such as trees that need the whole list.
+  // params carries list filters and the sort order, e.g. { sort: '-visited_at' }.
+  // prepareEdit adapts a loaded record for the edit form, e.g. parsing dates.
+  const { preparePayload, prepareEdit, loadAll, params = {} } = options;
   const items = ref([]);
   const loading = ref(false);
   const saving = ref(false);
   const dialogVisible = ref(false);
   const currentItem = ref(null);
+  // etag is the version of the record being edited; saving fails if it changed meanwhile.
+  const etag = ref(null);
+  const cursor = ref(null);
+  const hasMore = computed(() => cursor.value !== null);
+  const search = ref('');
   const toast = useToast();
 
+  // Searchable endpoints take a q parameter of at least two characters.
+  const listParams = () => {
+    const q = search.value.trim();
+    return q.length >= 2 ? { ...params, q } : params;
+  };
+
+  const fetchPage = async (after) => {
+    const response = await api.get(endpoint, { params: after ? { ...listParams(), after } : listParams() });
+    cursor.value = nextCursor(response);
+    return response.data;
+  };
+
   const loadItems = async () => {
     loading.value = true;
     try {
-      const { data } = await api.get(endpoint);
-      items.value = data;
+      items.value = loadAll ? await fetchAll(endpoint, listParams()) : await fetchPage(null);
+    } catch (error) {
+      console.error(error);
+      toast.add({ severity: 'error', summary: 'Ошибка', detail: 'Не удалось загрузить данные', life: 3000 });
+    } finally {
+      loading.value = false;
+    }
+  };
+
+  const loadMore = async () => {
+    if (!hasMore.value) {
+      return;
+    }
+    loading.value = true;
+    try {
+      items.value = [...items.value, ...(await fetchPage(cursor.value))];
     } catch (error) {
       console.error(error);
       toast.add({ severity: 'error', summary: 'Ошибка', detail: 'Не удалось загрузить данные', life: 3000 });
@@ -24,14 +58,28 @@ export function useCrud(endpoint, createDefault, options = {}) {
     }
   };
 
+  let searchTimer = null;
+  watch(search, () => {
+    clearTimeout(searchTimer);
+    searchTimer = setTimeout(loadItems, 300);
+  });
+
   const openCreate = (initialValues = {}) => {
     currentItem.value = { ...createDefault(), ...initialValues };
+    etag.value = null;
     dialogVisible.value = true;
   };
 
-  const openEdit = (item) => {
-    currentItem.value = { ...item };
-    dialogVisible.value = true;
+  const openEdit = async (item) => {
+    try {
+      const response = await api.get(`${endpoint}/${item.id}`);
+      currentItem.value = prepareEdit ? prepareEdit({ ...response.data }) : { ...response.data };
+      etag.value = response.headers.etag || null;
+      dialogVisible.value = true;
+    } catch (error) {
+      console.error(error);
+      toast.add({ severity: 'error', summary: 'Ошибка', detail: 'Не удалось загрузить запись', life: 3000 });
+    }
   };
 
   const buildPayload = () => {
@@ -46,7 +94,12 @@ export function useCrud(endpoint, createDefault, options = {}) {
     try {
       const payload = buildPayload();
       if (currentItem.value.id) {
-        await api.put(`${endpoint}/${currentItem.value.id}`, payload);
+        // A merge patch only writes the fields that changed; null clears optional fields.
+        const headers = { 'Content-Type': 'application/merge-patch+json' };
+        if (etag.value) {
+          headers['If-Match'] = etag.value;
+        }
+        await api.patch(`${endpoint}/${currentItem.value.id}`, payload, { headers });
         toast.add({ severity: 'success', summary: 'Сохранено', detail: 'Запись обновлена', life: 2000 });
       } else {
         const { data } = await api.post(endpoint, payload);
@@ -57,6 +110,17 @@ export function useCrud(endpoint, createDefault, options = {}) {
       dialogVisible.value = false;
     } catch (error) {
       console.error(error);
+      if (error.response?.status === 412) {
+        toast.add({
+          severity: 'warn',
+          summary: 'Конфликт',
+          detail: 'Запись уже изменил другой пользователь. Откройте её заново, чтобы увидеть изменения',
+          life: 5000,
+        });
+        dialogVisible.value = false;
+        await loadItems();
+        return;
+      }
       toast.add({ severity: 'error', summary: 'Ошибка', detail: 'Не удалось сохранить запись', life: 3000 });
     } finally {
       saving.value = false;
@@ -80,7 +144,10 @@ export function useCrud(endpoint, createDefault, options = {}) {
     saving,
     dialogVisible,
     currentItem,
+    hasMore,
+    search,
     loadItems,
+    loadMore,
     openCreate,
     openEdit,
     saveItem,
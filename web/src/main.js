@@ -18,6 +18,7 @@ import Divider from 'primevue/divider';
 import InputNumber from 'primevue/inputnumber';
 import Tree from 'primevue/tree';
 import Drawer from 'primevue/drawer';
+import Tag from 'primevue/tag';
 
 import Lara from '@primevue/themes/lara';
 import 'primeicons/primeicons.css';
@@ -57,5 +58,6 @@ app.component('Divider', Divider);
 app.component('InputNumber', InputNumber);
 app.component('Tree', Tree);
 app.component('Drawer', Drawer);
+app.component('Tag', Tag);
 
 app.mount('#app');
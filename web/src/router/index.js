@@ -3,6 +3,9 @@ import { useAuthStore } from '../stores/auth';
 
 const AppLayout = () => import('../components/layouts/AppLayout.vue');
 const LoginView = () => import('../views/LoginView.vue');
+const PasswordResetView = () => import('../views/PasswordResetView.vue');
+const OidcCallbackView = () => import('../views/OidcCallbackView.vue');
+const AcceptInvitationView = () => import('../views/AcceptInvitationView.vue');
 const UsersView = () => import('../views/UsersView.vue');
 const CompaniesView = () => import('../views/CompaniesView.vue');
 const RetailPointsView = () => import('../views/RetailPointsView.vue');
@@ -22,6 +25,24 @@ const router = createRouter({
       component: LoginView,
       meta: { public: true },
     },
+    {
+      path: '/login/oidc',
+      name: 'login-oidc',
+      component: OidcCallbackView,
+      meta: { public: true },
+    },
+    {
+      path: '/reset-password',
+      name: 'reset-password',
+      component: PasswordResetView,
+      meta: { public: true },
+    },
+    {
+      path: '/accept-invitation',
+      name: 'accept-invitation',
+      component: AcceptInvitationView,
+      meta: { public: true },
+    },
     {
       path: '/',
       component: AppLayout,
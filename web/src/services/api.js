@@ -17,12 +17,44 @@ api.interceptors.request.use((config) => {
   return config;
 });
 
+let refreshRequest = null;
+
+const refreshTokens = async () => {
+  const auth = useAuthStore();
+  if (!auth.refreshToken) {
+    throw new Error('missing refresh token');
+  }
+  const { data } = await axios.post(`${baseURL}/auth/refresh`, { refresh_token: auth.refreshToken });
+  auth.setToken(data.token, null, data.refresh_token);
+  return data.token;
+};
+
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
+  async (error) => {
     const status = error.response?.status;
+    const original = error.config;
+    const auth = useAuthStore();
+    if (status === 401 && auth.isImpersonating) {
+      // Impersonation sessions cannot be refreshed; return to the administrator's own session.
+      auth.stopImpersonation();
+      router.push({ name: 'users' });
+      return Promise.reject(error);
+    }
+    if (status === 401 && original && !original._retried && !original.url?.startsWith('/auth/')) {
+      original._retried = true;
+      try {
+        refreshRequest = refreshRequest || refreshTokens();
+        const token = await refreshRequest;
+        original.headers.Authorization = `Bearer ${token}`;
+        return api(original);
+      } catch (refreshError) {
+        console.error(refreshError);
+      } finally {
+        refreshRequest = null;
+      }
+    }
     if (status === 401) {
-      const auth = useAuthStore();
       auth.logout();
       if (router.currentRoute.value.name !== 'login') {
         router.push({ name: 'login', query: { redirect: router.currentRoute.value.fullPath } });
@@ -32,4 +64,25 @@ api.interceptors.response.use(
   }
 );
 
+// nextCursor extracts the "after" cursor of the next page from a Link header.
+export const nextCursor = (response) => {
+  const match = /<([^>]*)>;\s*rel="next"/.exec(response.headers?.link || '');
+  if (!match) {
+    return null;
+  }
+  return new URL(match[1], window.location.origin).searchParams.get('after');
+};
+
+// fetchAll loads every page of a list endpoint, e.g. for dropdown options.
+export const fetchAll = async (url, params = {}) => {
+  const items = [];
+  let after = null;
+  do {
+    const response = await api.get(url, { params: { ...params, limit: 200, ...(after ? { after } : {}) } });
+    items.push(...response.data);
+    after = nextCursor(response);
+  } while (after);
+  return items;
+};
+
 export default api;
@@ -1,29 +1,58 @@
 import { defineStore } from 'pinia';
 
 const TOKEN_KEY = 'merch_app_token';
+const REFRESH_TOKEN_KEY = 'merch_app_refresh_token';
+const IMPERSONATOR_KEY = 'merch_app_impersonator';
 
 export const useAuthStore = defineStore('auth', {
   state: () => ({
     token: localStorage.getItem(TOKEN_KEY) || '',
+    refreshToken: localStorage.getItem(REFRESH_TOKEN_KEY) || '',
     userEmail: localStorage.getItem('merch_app_email') || '',
+    impersonator: JSON.parse(localStorage.getItem(IMPERSONATOR_KEY) || 'null'),
   }),
   getters: {
     isAuthenticated: (state) => Boolean(state.token),
+    isImpersonating: (state) => Boolean(state.impersonator),
   },
   actions: {
-    setToken(token, email) {
+    setToken(token, email, refreshToken) {
       this.token = token;
       localStorage.setItem(TOKEN_KEY, token);
+      if (refreshToken) {
+        this.refreshToken = refreshToken;
+        localStorage.setItem(REFRESH_TOKEN_KEY, refreshToken);
+      }
       if (email) {
         this.userEmail = email;
         localStorage.setItem('merch_app_email', email);
       }
     },
+    // The administrator's own session is kept aside while acting as another user.
+    startImpersonation(token, email) {
+      this.impersonator = { token: this.token, refreshToken: this.refreshToken, email: this.userEmail };
+      localStorage.setItem(IMPERSONATOR_KEY, JSON.stringify(this.impersonator));
+      this.refreshToken = '';
+      localStorage.removeItem(REFRESH_TOKEN_KEY);
+      this.setToken(token, email);
+    },
+    stopImpersonation() {
+      const original = this.impersonator;
+      this.impersonator = null;
+      localStorage.removeItem(IMPERSONATOR_KEY);
+      if (original) {
+        this.setToken(original.token, original.email, original.refreshToken);
+      }
+    },
     logout() {
       this.token = '';
+      this.refreshToken = '';
       this.userEmail = '';
+      this.impersonator = null;
       localStorage.removeItem(TOKEN_KEY);
+      localStorage.removeItem(REFRESH_TOKEN_KEY);
       localStorage.removeItem('merch_app_email');
+      localStorage.removeItem(IMPERSONATOR_KEY);
     },
   },
 });